@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The signed-in user's account
+
+// recentLoginMaxAge is how long after logging in a user may confirm a
+// sensitive change without their password, for users who log in with an
+// identity provider or a passkey and may not have one.
+const recentLoginMaxAge = 10 * time.Minute
+
+// confirmAccountOwner checks that a sensitive change comes from the
+// account's owner rather than just someone holding an access token: the
+// password, a second-factor code, or a session from a recent login will do.
+// It returns why the change was refused, or "" if it may go ahead.
+func (cfg *apiConfig) confirmAccountOwner(ctx context.Context, principal *auth.Principal, dbUser database.User, password string, code string, recoveryCode string) (string, error) {
+	switch {
+	case password != "":
+		err := cfg.checkPassword(ctx, dbUser, password)
+		if err != nil {
+			return "Incorrect password", nil
+		}
+	case code != "" || recoveryCode != "":
+		ok, err := cfg.checkSecondFactor(ctx, dbUser.ID, code, recoveryCode)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "Incorrect code", nil
+		}
+	case principal.AuthTime.IsZero() || time.Since(principal.AuthTime) > recentLoginMaxAge:
+		return "Confirm with your password or a second-factor code, or log in again", nil
+	}
+	return "", nil
+}
+
+// userETag is derived from updated_at, which every change to the user
+// bumps. Postgres keeps microseconds, so that's the resolution used here.
+func userETag(dbUser database.User) string {
+	return fmt.Sprintf(`"%x"`, dbUser.UpdatedAt.UnixMicro())
+}
+
+// ifMatch reports whether an If-Match header allows a write to a resource
+// with the given ETag. An absent header allows anything; weak validators
+// never match, as RFC 9110 requires strong comparison here.
+func ifMatch(header string, etag string) bool {
+	if header == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) getCurrentUserHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "User not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.Header().Set("ETag", userETag(dbUser))
+	sendJsonSuccessResponse(writer, UserFromDb(dbUser))
+}
+
+// decodeMergePatchString reads one member of a JSON merge patch (RFC 7396).
+// null is returned as the empty string, which clears the field.
+func decodeMergePatchString(field string, raw json.RawMessage) (string, *FieldError) {
+	if string(raw) == "null" {
+		return "", nil
+	}
+	value := ""
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return "", &FieldError{Field: field, Code: "invalid", Message: field + " must be a string or null"}
+	}
+	return value, nil
+}
+
+// patchCurrentUserHandler applies a JSON merge patch to the signed-in user.
+// Any subset of the profile fields, email and password may be sent; fields
+// that are left out keep their values.
+func (cfg *apiConfig) patchCurrentUserHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	mediaType, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		writer.Header().Set("Accept-Patch", "application/merge-patch+json")
+		sendJsonError(writer, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch := map[string]json.RawMessage{}
+	err := decodePostBody(request.Body, &patch)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	// Fields are taken in order so the errors come back in the same order
+	// every time.
+	values := map[string]string{}
+	fieldErrors := []FieldError{}
+	for _, field := range slices.Sorted(maps.Keys(patch)) {
+		raw := patch[field]
+		switch field {
+		case "handle", "display_name", "bio", "location", "website", "email", "password", "current_password":
+			value, fieldErr := decodeMergePatchString(field, raw)
+			if fieldErr != nil {
+				fieldErrors = append(fieldErrors, *fieldErr)
+			}
+			values[field] = value
+		case "avatar_url":
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Code: "read_only", Message: "Upload an avatar to /api/users/me/avatar instead"})
+		default:
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Code: "unknown", Message: "Unknown field " + field})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
+		return
+	}
+
+	_, changingEmail := values["email"]
+	_, changingPassword := values["password"]
+	if (changingEmail || changingPassword) && !checkScope(writer, principal, auth.ScopeAccountManage) {
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the row makes the If-Match check and the write atomic.
+	qtx := cfg.withTx(tx)
+	dbUser, err := qtx.GetUserForUpdate(request.Context(), principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "User not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if !ifMatch(request.Header.Get("If-Match"), userETag(dbUser)) {
+		writer.Header().Set("ETag", userETag(dbUser))
+		sendJsonError(writer, "The user has been changed since it was fetched", http.StatusPreconditionFailed)
+		return
+	}
+
+	newEmail := dbUser.Email
+	if changingEmail {
+		newEmail, err = mail.NormalizeAddress(values["email"])
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "email", Code: "invalid", Message: err.Error()})
+		}
+	}
+
+	if changingPassword {
+		// Holding an access token isn't proof enough to take over the account.
+		// This can't go through checkPassword, whose rehash would wait on the
+		// row lock held here, and the hash is about to be replaced anyway.
+		_, err = cfg.passwords.Verify(values["current_password"], dbUser.HashedPassword)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "current_password", Code: "incorrect", Message: "Current password is incorrect"})
+		}
+		fieldErrors = append(fieldErrors, cfg.checkPasswordPolicy(values["password"], newEmail, dbUser.Email)...)
+	}
+
+	update := profileUpdate{
+		Handle:      dbUser.Handle.String,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		Location:    dbUser.Location,
+		Website:     dbUser.Website,
+	}
+	changingProfile := false
+	for _, profileField := range []struct {
+		name   string
+		target *string
+	}{
+		{"handle", &update.Handle},
+		{"display_name", &update.DisplayName},
+		{"bio", &update.Bio},
+		{"location", &update.Location},
+		{"website", &update.Website},
+	} {
+		value, ok := values[profileField.name]
+		if ok {
+			*profileField.target = value
+			changingProfile = true
+		}
+	}
+	profileParams, profileErrors := update.validate(dbUser.ID)
+	if changingProfile {
+		fieldErrors = append(fieldErrors, profileErrors...)
+	}
+
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
+		return
+	}
+
+	if changingProfile {
+		_, err = qtx.UpdateUserProfile(request.Context(), profileParams)
+		if isHandleTaken(err) {
+			sendHandleTakenError(writer)
+			return
+		}
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+	}
+
+	if changingPassword {
+		hash, err := cfg.passwords.Hash(values["password"])
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+		err = qtx.UpdateUserPassword(request.Context(), database.UpdateUserPasswordParams{ID: dbUser.ID, HashedPassword: hash})
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+		err = enqueueEmail(request.Context(), qtx, dbUser.Email, "Your Chirpy password was changed",
+			"The password for your Chirpy account was just changed.\n\n"+
+				"If this wasn't you, reset your password straight away.\n",
+		)
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+	}
+
+	// As with PUT, a new email address only takes effect once confirmed.
+	if changingEmail && newEmail != dbUser.Email {
+		err = cfg.queueEmailChange(request.Context(), qtx, dbUser, newEmail)
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+	}
+
+	dbUser, err = qtx.GetUser(request.Context(), dbUser.ID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.Header().Set("ETag", userETag(dbUser))
+	sendJsonSuccessResponse(writer, UserFromDb(dbUser))
+}
+
+// deleteCurrentUserHandler schedules the signed-in user's account for
+// deletion. Nothing is removed until the grace period is over, so a user
+// who changes their mind, or whose session was hijacked, can log in and
+// cancel. It must be confirmed with the password, a second-factor code, or
+// a session from a recent login.
+func (cfg *apiConfig) deleteCurrentUserHandler(writer http.ResponseWriter, request *http.Request) {
+	type deleteUserPostBody struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	type deleteUserResponse struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := deleteUserPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	refusal, err := cfg.confirmAccountOwner(request.Context(), principal, dbUser, params.Password, params.Code, params.RecoveryCode)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if refusal != "" {
+		sendJsonForbiddenError(writer, refusal)
+		return
+	}
+	if dbUser.DeleteAfter.Valid {
+		sendJsonResponse(writer, deleteUserResponse{DeleteAfter: dbUser.DeleteAfter.Time}, http.StatusAccepted)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	dbUser, err = qtx.ScheduleUserDeletion(request.Context(), database.ScheduleUserDeletionParams{
+		ID: dbUser.ID, DeleteAfter: sql.NullTime{Time: time.Now().Add(cfg.deletionGracePeriod), Valid: true},
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = qtx.RevokeRefreshTokensForUser(request.Context(), dbUser.ID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = enqueueEmail(request.Context(), qtx, dbUser.Email, "Your Chirpy account will be deleted", fmt.Sprintf(
+		"Your Chirpy account and everything in it will be permanently deleted on %s.\n\n"+
+			"If you change your mind, log in before then and cancel the deletion. "+
+			"If this wasn't you, log in, cancel the deletion and reset your password.\n",
+		dbUser.DeleteAfter.Time.UTC().Format("2 January 2006 at 15:04 MST"),
+	))
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonResponse(writer, deleteUserResponse{DeleteAfter: dbUser.DeleteAfter.Time}, http.StatusAccepted)
+}
+
+func (cfg *apiConfig) cancelUserDeletionHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	rows, err := cfg.db.CancelUserDeletion(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonNotFoundError(writer, "No deletion is scheduled.")
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// purgeDeletedUsers removes accounts whose grace period is over. Their rows
+// elsewhere, exports included, go with them by cascade.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context) error {
+	userIDs, err := cfg.db.DeleteUsersPastGracePeriod(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(userIDs) > 0 {
+		slog.InfoContext(ctx, "Purged deleted accounts", "count", len(userIDs))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"strconv"
+)
+
+// Avatars
+const (
+	maxAvatarBytes     = 1 << 20
+	maxAvatarDimension = 4096
+)
+
+// avatarContentTypes maps the image formats accepted, as the image package
+// names them, to the type they are served with.
+var avatarContentTypes = map[string]string{
+	"gif":  "image/gif",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+}
+
+// avatarURL changes whenever the avatar does, so it can be cached for long.
+func (cfg *apiConfig) avatarURL(dbAvatar database.Avatar) string {
+	return fmt.Sprintf("%s/api/users/%s/avatar?v=%s", cfg.publicURL, dbAvatar.UserID, strconv.FormatInt(dbAvatar.UpdatedAt.UnixMilli(), 36))
+}
+
+// putAvatarHandler replaces the signed-in user's avatar with the image in
+// the request body. The format is checked from the image itself rather
+// than trusted from Content-Type.
+func (cfg *apiConfig) putAvatarHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	data, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxAvatarBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		sendJsonError(writer, fmt.Sprintf("Avatar must be at most %d bytes", maxAvatarBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	contentType, ok := avatarContentTypes[format]
+	if err != nil || !ok {
+		sendJsonError(writer, "Avatar must be a PNG, JPEG or GIF image", http.StatusUnsupportedMediaType)
+		return
+	}
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		sendJsonFieldErrors(writer, []FieldError{{
+			Field: "avatar", Code: "too_large", Message: fmt.Sprintf("Avatar must be at most %d pixels across", maxAvatarDimension),
+		}})
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	dbAvatar, err := qtx.UpsertAvatar(request.Context(), database.UpsertAvatarParams{
+		UserID: principal.UserID, ContentType: contentType, Data: data,
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	dbUser, err := qtx.SetUserAvatarURL(request.Context(), database.SetUserAvatarURLParams{
+		ID: principal.UserID, AvatarUrl: cfg.avatarURL(dbAvatar),
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, UserFromDb(dbUser))
+}
+
+func (cfg *apiConfig) deleteAvatarHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.DeleteAvatar(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	_, err = qtx.SetUserAvatarURL(request.Context(), database.SetUserAvatarURLParams{ID: principal.UserID, AvatarUrl: ""})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getAvatarHandler(writer http.ResponseWriter, request *http.Request) {
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		sendJsonNotFoundError(writer, "Avatar not found.")
+		return
+	}
+
+	dbAvatar, err := cfg.db.GetPublicAvatar(request.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "Avatar not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.Header().Set("Content-Type", dbAvatar.ContentType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(dbAvatar.Data)))
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("Content-Security-Policy", "default-src 'none'")
+	writer.Header().Set("Cache-Control", "public, max-age=86400")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(dbAvatar.Data)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/billing"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Billing
+const maxWebhookBodyBytes = 1 << 20
+
+var errWebhookUnknownUser = errors.New("webhook refers to an unknown user")
+
+type WebhookEvent struct {
+	ID          string          `json:"id"`
+	Provider    string          `json:"provider"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	Attempts    int32           `json:"attempts"`
+	LastError   *string         `json:"last_error"`
+}
+
+func WebhookEventFromDb(dbEvent database.WebhookEvent) *WebhookEvent {
+	return &WebhookEvent{
+		ID:          dbEvent.ID,
+		Provider:    dbEvent.Provider,
+		EventType:   dbEvent.EventType,
+		Payload:     dbEvent.Payload,
+		ReceivedAt:  dbEvent.ReceivedAt,
+		ProcessedAt: nullTimePtr(dbEvent.ProcessedAt),
+		Attempts:    dbEvent.Attempts,
+		LastError:   nullStringPtr(dbEvent.LastError),
+	}
+}
+
+// loadBilling sets up the providers named in BILLING_PROVIDERS, the first
+// of which is used for checkout, and the plans in BILLING_PLANS_FILE.
+func (cfg *apiConfig) loadBilling() error {
+	cfg.billingProviders = map[string]billing.Provider{}
+	for _, name := range strings.Split(getEnvDefault("BILLING_PROVIDERS", "polka"), ",") {
+		var provider billing.Provider
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "polka":
+			provider = &billing.Polka{
+				WebhookSecret: os.Getenv("POLKA_WEBHOOK_SECRET"),
+				APIKey:        os.Getenv("POLKA_API_KEY"),
+				BaseURL:       os.Getenv("POLKA_API_URL"),
+				Client:        &http.Client{Transport: &tracing.Transport{Provider: cfg.tracer}},
+			}
+		case "fake":
+			provider = &billing.Fake{Secret: os.Getenv("FAKE_BILLING_SECRET")}
+		default:
+			return fmt.Errorf("unknown billing provider %q", name)
+		}
+		if cfg.checkoutProvider == nil {
+			cfg.checkoutProvider = provider
+		}
+		cfg.billingProviders[provider.Name()] = provider
+	}
+
+	cfg.plans = billing.DefaultPlans
+	if path := os.Getenv("BILLING_PLANS_FILE"); path != "" {
+		plans, err := billing.LoadPlans(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		cfg.plans = plans
+	}
+	return nil
+}
+
+// applyBillingEvent makes the changes an event calls for. Events Chirpy has
+// no use for are still logged, and count as processed.
+func (cfg *apiConfig) applyBillingEvent(ctx context.Context, qtx *database.Queries, provider string, event billing.Event) error {
+	switch event.Type {
+	case billing.EventSubscriptionActivated, billing.EventSubscriptionRenewed, billing.EventSubscriptionCanceled, billing.EventPaymentFailed:
+	default:
+		return nil
+	}
+
+	userID, err := uuid.Parse(event.UserID)
+	if err != nil {
+		return errWebhookUnknownUser
+	}
+	switch event.Type {
+	case billing.EventSubscriptionCanceled:
+		return cfg.cancelSubscription(ctx, qtx, userID)
+	case billing.EventPaymentFailed:
+		return cfg.markSubscriptionPastDue(ctx, qtx, userID)
+	default:
+		return cfg.activateSubscription(ctx, qtx, userID, provider, event)
+	}
+}
+
+// processWebhookEvent applies a stored event. The row lock means concurrent
+// redeliveries of one event are applied once; unless force is set, as it is
+// for replays, an event that has already been processed is left alone.
+// Event IDs are only unique within a provider, so both identify the event.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, providerName string, id string, force bool) (database.WebhookEvent, error) {
+	key := database.GetWebhookEventForUpdateParams{Provider: providerName, ID: id}
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	dbEvent, err := qtx.GetWebhookEventForUpdate(ctx, key)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	if dbEvent.ProcessedAt.Valid && !force {
+		return dbEvent, nil
+	}
+
+	provider, ok := cfg.billingProviders[dbEvent.Provider]
+	if !ok {
+		return dbEvent, fmt.Errorf("billing provider %q is not configured", dbEvent.Provider)
+	}
+	event, err := provider.ParseEvent(dbEvent.Payload)
+	if err == nil {
+		err = cfg.applyBillingEvent(ctx, qtx, provider.Name(), event)
+	}
+	if errors.Is(err, errWebhookUnknownUser) {
+		// Retrying won't make the user exist, so the event is recorded as
+		// processed and the provider told it was received.
+		tx.Rollback()
+		markErr := cfg.db.MarkWebhookEventProcessed(ctx, database.MarkWebhookEventProcessedParams{
+			Provider: providerName, ID: id, LastError: sql.NullString{String: err.Error(), Valid: true},
+		})
+		if markErr != nil {
+			return dbEvent, markErr
+		}
+		return dbEvent, err
+	}
+	if err != nil {
+		tx.Rollback()
+		markErr := cfg.db.MarkWebhookEventFailed(ctx, database.MarkWebhookEventFailedParams{
+			Provider: providerName, ID: id, LastError: sql.NullString{String: err.Error(), Valid: true},
+		})
+		if markErr != nil {
+			slog.ErrorContext(ctx, "Error recording failure of webhook event", "provider", providerName, "event_id", id, "err", markErr)
+		}
+		return dbEvent, err
+	}
+
+	err = qtx.MarkWebhookEventProcessed(ctx, database.MarkWebhookEventProcessedParams{Provider: providerName, ID: id})
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	return cfg.db.GetWebhookEventForUpdate(ctx, key)
+}
+
+// billingWebhookHandler accepts signed deliveries from a billing provider.
+// Each is stored by provider and event ID before being applied, so redeliveries are
+// no-ops and failed events can be replayed.
+func (cfg *apiConfig) billingWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	provider, ok := cfg.billingProviders[request.PathValue("provider")]
+	if !ok {
+		sendJsonNotFoundError(writer, "Unknown billing provider.")
+		return
+	}
+	outcomes := cfg.metrics.WebhookEvents.MustCurryWith(prometheus.Labels{"provider": provider.Name()})
+
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxWebhookBodyBytes))
+	if err != nil {
+		outcomes.WithLabelValues("malformed").Inc()
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	err = provider.VerifyWebhook(request.Header, body, time.Now())
+	if errors.Is(err, billing.ErrWebhookSecretUnset) {
+		slog.WarnContext(request.Context(), "Rejecting webhook", "provider", provider.Name(), "err", err)
+		outcomes.WithLabelValues("rejected").Inc()
+		sendJsonUnauthorizedError(writer, "Unauthorized")
+		return
+	}
+	if err != nil {
+		outcomes.WithLabelValues("rejected").Inc()
+		sendJsonUnauthorizedError(writer, err.Error())
+		return
+	}
+
+	event, err := provider.ParseEvent(body)
+	if err != nil {
+		outcomes.WithLabelValues("malformed").Inc()
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	inserted, err := cfg.db.InsertWebhookEvent(request.Context(), database.InsertWebhookEventParams{
+		ID: event.ID, Provider: provider.Name(), EventType: event.ProviderType, Payload: body,
+	})
+	if err != nil {
+		outcomes.WithLabelValues("failed").Inc()
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	_, err = cfg.processWebhookEvent(request.Context(), provider.Name(), event.ID, false)
+	if errors.Is(err, errWebhookUnknownUser) {
+		slog.WarnContext(request.Context(), "Skipping webhook event", "provider", provider.Name(), "event_id", event.ID, "err", err)
+		outcomes.WithLabelValues("unknown_user").Inc()
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		outcomes.WithLabelValues("failed").Inc()
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	if inserted == 0 {
+		outcomes.WithLabelValues("duplicate").Inc()
+	} else {
+		outcomes.WithLabelValues("processed").Inc()
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// polkaWebHookHandler serves the URL Polka was first configured with.
+func (cfg *apiConfig) polkaWebHookHandler(writer http.ResponseWriter, request *http.Request) {
+	request.SetPathValue("provider", "polka")
+	cfg.billingWebhookHandler(writer, request)
+}
+
+func (cfg *apiConfig) getWebhookEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	limit := int64(50)
+	if value := request.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed < 1 || parsed > 500 {
+			sendJsonBadRequestError(writer, "limit must be between 1 and 500")
+			return
+		}
+		limit = parsed
+	}
+
+	dbEvents, err := cfg.db.GetWebhookEvents(request.Context(), database.GetWebhookEventsParams{
+		Limit: int32(limit), UnprocessedOnly: request.URL.Query().Get("unprocessed") == "true",
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	events := make([]*WebhookEvent, 0, len(dbEvents))
+	for _, dbEvent := range dbEvents {
+		events = append(events, WebhookEventFromDb(dbEvent))
+	}
+
+	sendJsonSuccessResponse(writer, events)
+}
+
+func (cfg *apiConfig) replayWebhookEventHandler(writer http.ResponseWriter, request *http.Request) {
+	dbEvent, err := cfg.processWebhookEvent(request.Context(), request.PathValue("provider"), request.PathValue("eventID"), true)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "Event not found.")
+		return
+	}
+	if errors.Is(err, errWebhookUnknownUser) {
+		sendJsonError(writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, WebhookEventFromDb(dbEvent))
+}
+
+func (cfg *apiConfig) getPlansHandler(writer http.ResponseWriter, request *http.Request) {
+	type plan struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		Price      int64  `json:"price"`
+		Currency   string `json:"currency"`
+		PeriodDays int    `json:"period_days"`
+	}
+
+	plans := make([]plan, 0, len(cfg.plans))
+	for _, p := range cfg.plans {
+		if cfg.checkoutProvider != nil && p.PriceIDs[cfg.checkoutProvider.Name()] == "" {
+			continue
+		}
+		plans = append(plans, plan{ID: p.ID, Name: p.Name, Price: p.Price, Currency: p.Currency, PeriodDays: p.PeriodDays})
+	}
+
+	sendJsonSuccessResponse(writer, plans)
+}
+
+func (cfg *apiConfig) createCheckoutSessionHandler(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Plan       string `json:"plan"`
+		SuccessURL string `json:"success_url"`
+		CancelURL  string `json:"cancel_url"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := parameters{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+	if cfg.checkoutProvider == nil {
+		sendJsonError(writer, "Checkout is not available", http.StatusServiceUnavailable)
+		return
+	}
+	plan, ok := billing.FindPlan(cfg.plans, params.Plan)
+	if !ok {
+		sendJsonBadRequestError(writer, "Unknown plan")
+		return
+	}
+	// Only send users back to Chirpy itself once they have paid.
+	for _, returnURL := range []*string{&params.SuccessURL, &params.CancelURL} {
+		if *returnURL == "" {
+			*returnURL = cfg.publicURL + "/app/"
+		} else if *returnURL != cfg.publicURL && !strings.HasPrefix(*returnURL, cfg.publicURL+"/") {
+			sendJsonBadRequestError(writer, "Return URLs must be on "+cfg.publicURL)
+			return
+		}
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	session, err := cfg.checkoutProvider.CreateCheckoutSession(request.Context(), billing.CheckoutRequest{
+		UserID:     dbUser.ID.String(),
+		Email:      dbUser.Email,
+		Plan:       plan,
+		SuccessURL: params.SuccessURL,
+		CancelURL:  params.CancelURL,
+	})
+	if errors.Is(err, billing.ErrPlanUnavailable) {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+	if errors.Is(err, billing.ErrCheckoutUnavailable) {
+		sendJsonError(writer, "Checkout is not available", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonCreatedResponse(writer, session)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/logging"
+	"pjh.id.au/chirpy/v2/internal/tracing"
+	"strconv"
+	"time"
+)
+
+// Configuration helpers
+
+// fatal logs an error that stops the server starting, and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newLoggerFromEnv reads LOG_LEVEL and LOG_FORMAT, either json or text.
+func newLoggerFromEnv() (*slog.Logger, error) {
+	level, err := logging.ParseLevel(getEnvDefault("LOG_LEVEL", "info"))
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(os.Stderr, logging.Options{Level: level, Format: getEnvDefault("LOG_FORMAT", "json")})
+}
+
+// newTracerProviderFromEnv reads TRACING_EXPORTER, one of none, otlp or
+// stdout, and OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_SERVICE_NAME.
+func newTracerProviderFromEnv(ctx context.Context) (trace.TracerProvider, func(context.Context) error, error) {
+	return tracing.Setup(ctx, tracing.Options{
+		Exporter:    getEnvDefault("TRACING_EXPORTER", tracing.ExporterNone),
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		ServiceName: getEnvDefault("OTEL_SERVICE_NAME", "chirpy"),
+	})
+}
+
+func getEnvDefault(name string, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		fatal("Error parsing environment variable", "name", name, "err", err)
+	}
+	return duration
+}
+
+func getEnvBool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		fatal("Error parsing environment variable", "name", name, "err", err)
+	}
+	return parsed
+}
+
+func getEnvUint(name string, fallback uint64, bitSize int) uint64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		fatal("Error parsing environment variable", "name", name, "err", err)
+	}
+	return parsed
+}
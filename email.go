@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"time"
+)
+
+// Email verification
+const (
+	emailVerificationLifetime = 24 * time.Hour
+	emailChangeLifetime       = time.Hour
+)
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (cfg *apiConfig) enqueueVerificationEmail(ctx context.Context, db *database.Queries, userID uuid.UUID, email string) error {
+	token, err := cfg.keyring.MakeEmailJWT(userID, email, auth.AudienceEmailVerification, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.publicURL + "/app/verify-email?token=" + url.QueryEscape(token)
+	return enqueueEmail(ctx, db, email, "Verify your Chirpy email address", fmt.Sprintf(
+		"Please confirm this is your email address by opening this link:\n\n%s\n\n"+
+			"If you didn't sign up for Chirpy, you can ignore this email.\n",
+		link,
+	))
+}
+
+// startEmailChange records newEmail as pending and mails a confirmation link
+// to it. The current address keeps working until the link is used, and gets
+// a notice in case the request wasn't made by its owner.
+func (cfg *apiConfig) startEmailChange(ctx context.Context, dbUser database.User, newEmail string) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = cfg.queueEmailChange(ctx, cfg.withTx(tx), dbUser, newEmail)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queueEmailChange records newEmail as pending and queues the confirmation
+// and notice emails, as part of the caller's transaction.
+func (cfg *apiConfig) queueEmailChange(ctx context.Context, qtx *database.Queries, dbUser database.User, newEmail string) error {
+	token, err := cfg.keyring.MakeEmailJWT(dbUser.ID, newEmail, auth.AudienceEmailChange, emailChangeLifetime)
+	if err != nil {
+		return err
+	}
+
+	err = qtx.SetUserPendingEmail(ctx, database.SetUserPendingEmailParams{
+		ID: dbUser.ID, PendingEmail: sql.NullString{String: newEmail, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.publicURL + "/app/confirm-email?token=" + url.QueryEscape(token)
+	err = enqueueEmail(ctx, qtx, newEmail, "Confirm your new Chirpy email address", fmt.Sprintf(
+		"To finish changing your Chirpy email address to %s, open this link within %d minutes:\n\n%s\n",
+		newEmail, int(emailChangeLifetime.Minutes()), link,
+	))
+	if err != nil {
+		return err
+	}
+	err = enqueueEmail(ctx, qtx, dbUser.Email, "Your Chirpy email address is being changed", fmt.Sprintf(
+		"Someone asked to change the email address on your Chirpy account to %s.\n\n"+
+			"Nothing changes until the new address is confirmed. If this wasn't you, reset your password.\n",
+		newEmail,
+	))
+	return err
+}
+
+func (cfg *apiConfig) verifyEmailHandler(writer http.ResponseWriter, request *http.Request) {
+	type verifyEmailPostBody struct {
+		Token string `json:"token"`
+	}
+
+	params := verifyEmailPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	claims, err := cfg.keyring.ParseJWTForAudience(request.Context(), params.Token, auth.AudienceEmailVerification)
+	if err != nil {
+		sendJsonBadRequestError(writer, "Invalid or expired verification link")
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		sendJsonBadRequestError(writer, "Invalid or expired verification link")
+		return
+	}
+
+	// Only the address the link was sent to can be verified by it.
+	rows, err := cfg.db.VerifyUserEmail(request.Context(), database.VerifyUserEmailParams{ID: userID, Email: claims.Email})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonBadRequestError(writer, "Invalid or expired verification link")
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) resendVerificationEmailHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if dbUser.VerifiedAt.Valid {
+		sendJsonConflictError(writer, "Email address is already verified")
+		return
+	}
+
+	err = cfg.enqueueVerificationEmail(request.Context(), cfg.db, dbUser.ID, dbUser.Email)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) changeEmailHandler(writer http.ResponseWriter, request *http.Request) {
+	type changeEmailPostBody struct {
+		Email string `json:"email"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := changeEmailPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	email, err := mail.NormalizeAddress(params.Email)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if email == dbUser.Email {
+		sendJsonBadRequestError(writer, "That is already your email address")
+		return
+	}
+
+	err = cfg.startEmailChange(request.Context(), dbUser, email)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) confirmEmailChangeHandler(writer http.ResponseWriter, request *http.Request) {
+	type confirmEmailPostBody struct {
+		Token string `json:"token"`
+	}
+
+	params := confirmEmailPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	claims, err := cfg.keyring.ParseJWTForAudience(request.Context(), params.Token, auth.AudienceEmailChange)
+	if err != nil {
+		sendJsonBadRequestError(writer, "Invalid or expired confirmation link")
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		sendJsonBadRequestError(writer, "Invalid or expired confirmation link")
+		return
+	}
+
+	// Matching on the pending address makes the link single use, and a newer
+	// change request invalidates older links.
+	dbUser, err := cfg.db.ConfirmUserEmailChange(request.Context(), database.ConfirmUserEmailChangeParams{
+		ID: userID, PendingEmail: sql.NullString{String: claims.Email, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonBadRequestError(writer, "Invalid or expired confirmation link")
+		return
+	}
+	if isUniqueViolation(err) {
+		sendJsonConflictError(writer, "Email address is already in use")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, UserFromDb(dbUser))
+}
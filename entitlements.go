@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/entitlements"
+)
+
+// Entitlements
+func entitlementsFor(dbUser database.User) entitlements.Entitlements {
+	if dbUser.IsChirpyRed {
+		return entitlements.For(entitlements.Red)
+	}
+	return entitlements.For(entitlements.Free)
+}
+
+func (cfg *apiConfig) getEntitlementsHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, entitlementsFor(dbUser))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Data export
+const staleExportAfter = 15 * time.Minute
+
+const exportReadme = `This archive holds everything Chirpy stores about your account.
+
+profile.json        your account and public profile
+chirps.json         every chirp you have posted
+sessions.json       the sessions you have signed in with, without their tokens
+access_tokens.json  your personal access tokens, without their secrets
+passkeys.json       the passkeys registered to your account
+oauth_clients.json  the OAuth applications you have registered
+media/              your avatar, if you have uploaded one
+`
+
+type DataExport struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Status      string     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// DownloadURL needs the user's access token, unlike the emailed link.
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func DataExportFromDb(dbExport database.DataExport) *DataExport {
+	return &DataExport{
+		ID:          dbExport.ID,
+		CreatedAt:   dbExport.CreatedAt,
+		Status:      dbExport.Status,
+		CompletedAt: nullTimePtr(dbExport.CompletedAt),
+		ExpiresAt:   nullTimePtr(dbExport.ExpiresAt),
+	}
+}
+
+// requestDataExportHandler queues an export, which is built in the
+// background and emailed as a link once ready. A user only has one export
+// in progress at a time.
+func (cfg *apiConfig) requestDataExportHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbExport, err := cfg.db.GetUnfinishedDataExportForUser(request.Context(), principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		dbExport, err = cfg.db.CreateDataExport(request.Context(), principal.UserID)
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonResponse(writer, DataExportFromDb(dbExport), http.StatusAccepted)
+}
+
+func (cfg *apiConfig) getDataExportsHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbExports, err := cfg.db.GetDataExportsForUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	exports := make([]*DataExport, 0, len(dbExports))
+	for _, dbExport := range dbExports {
+		export := DataExportFromDb(dbExport)
+		if dataExportAvailable(dbExport) {
+			export.DownloadURL = cfg.publicURL + "/api/users/me/exports/" + dbExport.ID.String() + "/download"
+		}
+		exports = append(exports, export)
+	}
+
+	sendJsonSuccessResponse(writer, exports)
+}
+
+func dataExportAvailable(dbExport database.DataExport) bool {
+	return dbExport.Status == "ready" && dbExport.ExpiresAt.Valid && dbExport.ExpiresAt.Time.After(time.Now())
+}
+
+// downloadDataExportHandler serves an export to whoever holds the emailed
+// link, since the link is usually opened in a browser without an access
+// token.
+func (cfg *apiConfig) downloadDataExportHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := uuid.Parse(request.PathValue("exportID"))
+	if err != nil {
+		sendJsonNotFoundError(writer, "Export not found.")
+		return
+	}
+	tokenHash := sql.NullString{String: auth.HashToken(request.URL.Query().Get("token")), Valid: true}
+
+	dbExport, err := cfg.db.GetDataExportByTokenHash(request.Context(), database.GetDataExportByTokenHashParams{ID: id, TokenHash: tokenHash})
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "Export not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	cfg.sendDataExport(writer, request, dbExport)
+}
+
+// downloadOwnDataExportHandler serves an export to the user it belongs to.
+func (cfg *apiConfig) downloadOwnDataExportHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	id, err := uuid.Parse(request.PathValue("exportID"))
+	if err != nil {
+		sendJsonNotFoundError(writer, "Export not found.")
+		return
+	}
+	dbExport, err := cfg.db.GetDataExport(request.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && dbExport.UserID != principal.UserID) {
+		sendJsonNotFoundError(writer, "Export not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if dbExport.Status != "ready" {
+		sendJsonError(writer, "This export isn't ready", http.StatusConflict)
+		return
+	}
+
+	cfg.sendDataExport(writer, request, dbExport)
+}
+
+func (cfg *apiConfig) sendDataExport(writer http.ResponseWriter, request *http.Request, dbExport database.DataExport) {
+	if !dataExportAvailable(dbExport) {
+		sendJsonError(writer, "This download link has expired", http.StatusGone)
+		return
+	}
+
+	data, err := cfg.db.GetDataExportArchive(request.Context(), dbExport.ID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, dbExport.CreatedAt.Format("2006-01-02")))
+	writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	_, err = writer.Write(data)
+	if err != nil {
+		slog.WarnContext(request.Context(), "Error sending export", "export_id", dbExport.ID, "err", err)
+	}
+}
+
+// processDataExports builds queued exports until none are left.
+func (cfg *apiConfig) processDataExports(ctx context.Context) error {
+	for ctx.Err() == nil {
+		dbExport, err := cfg.db.ClaimDataExport(ctx, staleExportAfter.Seconds())
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = cfg.completeDataExport(ctx, dbExport)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error building export", "export_id", dbExport.ID, "err", err)
+			err = cfg.db.FailDataExport(ctx, database.FailDataExportParams{
+				ID: dbExport.ID, LastError: sql.NullString{String: err.Error(), Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (cfg *apiConfig) completeDataExport(ctx context.Context, dbExport database.DataExport) error {
+	dbUser, err := cfg.db.GetUser(ctx, dbExport.UserID)
+	if err != nil {
+		return err
+	}
+
+	// The archive is kept in the database, like avatars, so any server can
+	// serve it and it goes by cascade when the account is purged.
+	data, err := cfg.buildDataExport(ctx, dbUser)
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(cfg.exportLinkLifetime)
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.SaveDataExportArchive(ctx, database.SaveDataExportArchiveParams{ExportID: dbExport.ID, Data: data})
+	if err != nil {
+		return err
+	}
+	err = qtx.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:        dbExport.ID,
+		TokenHash: sql.NullString{String: auth.HashToken(token), Valid: true},
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	link := cfg.publicURL + "/api/exports/" + dbExport.ID.String() + "?token=" + url.QueryEscape(token)
+	err = enqueueEmail(ctx, qtx, dbUser.Email, "Your Chirpy data export is ready", fmt.Sprintf(
+		"The export of your Chirpy data is ready. Download it within %d hours:\n\n%s\n\n"+
+			"If you didn't ask for this, reset your password.\n",
+		int(cfg.exportLinkLifetime.Hours()), link,
+	))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) buildDataExport(ctx context.Context, dbUser database.User) ([]byte, error) {
+	dbChirps, err := cfg.db.GetChirpsByAuthor(ctx, database.GetChirpsByAuthorParams{UserID: dbUser.ID})
+	if err != nil {
+		return nil, err
+	}
+	chirps := make([]*Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, ChirpFromDb(dbChirp))
+	}
+
+	type session struct {
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt time.Time  `json:"expires_at"`
+		RevokedAt *time.Time `json:"revoked_at"`
+		ClientID  *string    `json:"client_id"`
+		Scopes    []string   `json:"scopes"`
+	}
+	dbRefreshTokens, err := cfg.db.GetRefreshTokensForUser(ctx, dbUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]session, 0, len(dbRefreshTokens))
+	for _, dbRefreshToken := range dbRefreshTokens {
+		sessions = append(sessions, session{
+			CreatedAt: dbRefreshToken.CreatedAt,
+			ExpiresAt: dbRefreshToken.ExpiresAt,
+			RevokedAt: nullTimePtr(dbRefreshToken.RevokedAt),
+			ClientID:  nullStringPtr(dbRefreshToken.ClientID),
+			Scopes:    dbRefreshToken.Scopes,
+		})
+	}
+
+	dbTokens, err := cfg.db.GetPersonalAccessTokensForUser(ctx, dbUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*PersonalAccessToken, 0, len(dbTokens))
+	for _, dbToken := range dbTokens {
+		tokens = append(tokens, PersonalAccessTokenFromDb(dbToken))
+	}
+
+	dbCredentials, err := cfg.db.GetWebAuthnCredentialsForUser(ctx, dbUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys := make([]*Passkey, 0, len(dbCredentials))
+	for _, dbCredential := range dbCredentials {
+		passkeys = append(passkeys, PasskeyFromDb(dbCredential))
+	}
+
+	dbClients, err := cfg.db.GetOAuthClientsForOwner(ctx, dbUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*OAuthClient, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		clients = append(clients, OAuthClientFromDb(dbClient))
+	}
+
+	dbAvatar, err := cfg.db.GetAvatar(ctx, dbUser.ID)
+	hasAvatar := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	err = writeZipFile(archive, "README.txt", []byte(exportReadme))
+	if err != nil {
+		return nil, err
+	}
+	for name, contents := range map[string]any{
+		"profile.json":       UserFromDb(dbUser),
+		"chirps.json":        chirps,
+		"sessions.json":      sessions,
+		"access_tokens.json": tokens,
+		"passkeys.json":      passkeys,
+		"oauth_clients.json": clients,
+	} {
+		data, err := json.MarshalIndent(contents, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		err = writeZipFile(archive, name, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hasAvatar {
+		extension := strings.TrimPrefix(dbAvatar.ContentType, "image/")
+		err = writeZipFile(archive, "media/avatar."+extension, dbAvatar.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeZipFile(archive *zip.Writer, name string, data []byte) error {
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(data)
+	return err
+}
+
+// deleteExpiredDataExports removes exports whose links have expired, and
+// their archives with them.
+func (cfg *apiConfig) deleteExpiredDataExports(ctx context.Context) error {
+	return cfg.db.DeleteExpiredDataExports(ctx)
+}
@@ -1,89 +1,68 @@
 package auth
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"strings"
 	"time"
 )
 
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err
-}
-
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	now := time.Now().UTC()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer: "chirpy", Subject: userID.String(),
-		IssuedAt: &jwt.NumericDate{Time: now}, ExpiresAt: &jwt.NumericDate{Time: now.Add(expiresIn)},
-	})
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims(Principal{UserID: userID}, DefaultTokenOptions, expiresIn))
 
 	return token.SignedString([]byte(tokenSecret))
 }
 
 func ValidateJWT(tokenString string, tokenSecret string) (uuid.UUID, error) {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+	claims, err := parseClaims(tokenString, DefaultTokenOptions, []string{jwt.SigningMethodHS256.Alg()}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, true)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	subject, err := claims.GetSubject()
+	userID, err := claims.UserID()
 	if err != nil {
-		return uuid.Nil, err
-	}
-	userID, err := uuid.Parse(subject)
-	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
 	}
 	return userID, nil
 }
 
-func GetBearerToken(headers http.Header) (string, error) {
+var (
+	ErrNoAuthHeader        = errors.New("no authorization header")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+)
+
+// getAuthorization returns the credentials from an Authorization header using
+// the given scheme. A header using some other scheme counts as missing, so
+// callers can try each scheme they accept in turn.
+func getAuthorization(headers http.Header, scheme string) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
-		return "", nil
+		return "", ErrNoAuthHeader
+	}
+	authScheme, credentials, found := strings.Cut(authHeader, " ")
+	if !strings.EqualFold(authScheme, scheme) {
+		return "", ErrNoAuthHeader
 	}
-	bearerToken := strings.Split(authHeader, " ")
-	if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
-		return "", nil
+	credentials = strings.TrimSpace(credentials)
+	if !found || credentials == "" || strings.Contains(credentials, " ") {
+		return "", ErrMalformedAuthHeader
 	}
-	return bearerToken[1], nil
+	return credentials, nil
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	return getAuthorization(headers, "bearer")
 }
 
 func GetAPIKey(headers http.Header) (string, error) {
-	authHeader := headers.Get("Authorization")
-	if authHeader == "" {
-		return "", nil
-	}
-	apiKey := strings.Split(authHeader, " ")
-	if len(apiKey) != 2 || strings.ToLower(apiKey[0]) != "apikey" {
-		return "", nil
-	}
-	return apiKey[1], nil
+	return getAuthorization(headers, "apikey")
 }
 
 func MakeRefreshToken() (string, error) {
-	buf := make([]byte, 32)
-	_, err := rand.Read(buf)
-	if err != nil {
-		return "", err
-	}
-
-	return hex.EncodeToString(buf), nil
+	return MakeOpaqueToken()
 }
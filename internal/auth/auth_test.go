@@ -1,44 +1,53 @@
 package auth
 
 import (
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestHashPassword(t *testing.T) {
-	hashed, err := HashPassword("password")
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+	hashed, err := hasher.Hash("password")
 	if err != nil {
 		t.Error(err)
 	}
 
-	checkErr := bcrypt.CompareHashAndPassword([]byte(hashed), []byte("password"))
+	if !strings.HasPrefix(hashed, "$argon2id$") {
+		t.Errorf("Expected an argon2id hash, got %q", hashed)
+	}
+	_, checkErr := hasher.Verify("password", hashed)
 	if checkErr != nil {
-		t.Error(err)
+		t.Error(checkErr)
 	}
 }
 
 func TestCheckPassword(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
 	hashed, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	if err != nil {
 		t.Error(err)
 	}
 
-	checkErr := CheckPasswordHash("password", string(hashed))
+	_, checkErr := hasher.Verify("password", string(hashed))
 	if checkErr != nil {
 		t.Error(err)
 	}
 }
 
 func TestCheckPasswordBlank(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
 	hashed, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	if err != nil {
 		t.Error(err)
 	}
 
-	checkErr := CheckPasswordHash("", string(hashed))
+	_, checkErr := hasher.Verify("", string(hashed))
 	if checkErr == nil {
 		t.Error("Expected error, got nil")
 	}
@@ -89,7 +98,68 @@ func TestValidateJWT(t *testing.T) {
 	// Test expired token
 	expiredToken, _ := MakeJWT(userID, secret, -time.Hour)
 	_, err = ValidateJWT(expiredToken, secret)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired for expired token, got %v", err)
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	headers := http.Header{}
+	_, err := GetBearerToken(headers)
+	if !errors.Is(err, ErrNoAuthHeader) {
+		t.Errorf("Expected ErrNoAuthHeader, got %v", err)
+	}
+
+	headers.Set("Authorization", "ApiKey abc")
+	_, err = GetBearerToken(headers)
+	if !errors.Is(err, ErrNoAuthHeader) {
+		t.Errorf("Expected ErrNoAuthHeader for other scheme, got %v", err)
+	}
+
+	headers.Set("Authorization", "Bearer")
+	_, err = GetBearerToken(headers)
+	if !errors.Is(err, ErrMalformedAuthHeader) {
+		t.Errorf("Expected ErrMalformedAuthHeader, got %v", err)
+	}
+
+	headers.Set("Authorization", "Bearer abc def")
+	_, err = GetBearerToken(headers)
+	if !errors.Is(err, ErrMalformedAuthHeader) {
+		t.Errorf("Expected ErrMalformedAuthHeader, got %v", err)
+	}
+
+	headers.Set("Authorization", "bearer abc")
+	token, err := GetBearerToken(headers)
+	if err != nil || token != "abc" {
+		t.Errorf("Expected token abc, got %q (%v)", token, err)
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, prefix, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("Expected %q to be recognised as a personal access token", token)
+	}
+	if !strings.HasPrefix(token, prefix) || len(prefix) >= len(token) {
+		t.Errorf("Expected %q to be a short prefix of %q", prefix, token)
+	}
+	if HashToken(token) == token || HashToken(token) != HashToken(token) {
+		t.Error("Expected a stable hash distinct from the token")
+	}
+
+	err = ValidateScopes([]string{ScopeChirpsWrite}, PersonalAccessTokenScopes)
+	if err != nil {
+		t.Errorf("Expected valid scopes, got %v", err)
+	}
+	err = ValidateScopes([]string{ScopeTokensManage}, PersonalAccessTokenScopes)
+	if err == nil {
+		t.Error("Expected error for scope not grantable to tokens, got nil")
+	}
+	err = ValidateScopes(nil, PersonalAccessTokenScopes)
 	if err == nil {
-		t.Error("Expected error for expired token, got nil")
+		t.Error("Expected error for no scopes, got nil")
 	}
 }
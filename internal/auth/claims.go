@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultIssuer   = "chirpy"
+	DefaultAudience = "chirpy-api"
+)
+
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token has expired")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenIssuer           = errors.New("token has the wrong issuer")
+	ErrTokenAudience         = errors.New("token has the wrong audience")
+	ErrTokenInvalid          = errors.New("token is invalid")
+)
+
+// TokenOptions controls what MakeJWT puts in a token and what ValidateJWT accepts.
+type TokenOptions struct {
+	Issuer string
+	// PreviousIssuers are also accepted in iss, so tokens issued before the
+	// issuer changed keep working until they expire.
+	PreviousIssuers []string
+	Audience        string
+	// ClockSkew is how far exp, nbf and iat may be off from our clock.
+	ClockSkew time.Duration
+}
+
+var DefaultTokenOptions = TokenOptions{Issuer: DefaultIssuer, Audience: DefaultAudience}
+
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// Scope is space-separated, as in RFC 8693.
+	Scope string `json:"scope,omitempty"`
+	// Email is only set on signed email links.
+	Email string `json:"email,omitempty"`
+	// ClientID names the OAuth client a token was issued to, as in RFC 9068.
+	ClientID string `json:"client_id,omitempty"`
+	// AuthTime is when the session's login happened, as in OpenID Connect.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+}
+
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+func (c *Claims) Principal() (*Principal, error) {
+	userID, err := c.UserID()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	principal := &Principal{UserID: userID, Roles: c.Roles, Scopes: strings.Fields(c.Scope), TokenID: c.ID, ClientID: c.ClientID}
+	if c.AuthTime != nil {
+		principal.AuthTime = c.AuthTime.Time
+	}
+	return principal, nil
+}
+
+func newClaims(principal Principal, options TokenOptions, expiresIn time.Duration) *Claims {
+	now := time.Now().UTC()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: options.Issuer, Subject: principal.UserID.String(), Audience: jwt.ClaimStrings{options.Audience},
+			IssuedAt: &jwt.NumericDate{Time: now}, ExpiresAt: &jwt.NumericDate{Time: now.Add(expiresIn)},
+			ID: uuid.NewString(),
+		},
+		Roles:    principal.Roles,
+		Scope:    strings.Join(principal.Scopes, " "),
+		ClientID: principal.ClientID,
+	}
+	if !principal.AuthTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(principal.AuthTime)
+	}
+	return claims
+}
+
+// parseClaims validates a token's signature and claims. If allowLegacy is
+// set, a token signed with HS256 may leave out aud: the HS256 access tokens
+// issued before audiences were introduced never carried one, and they keep
+// working until they expire. They were all first-party sessions, so they
+// get the session scopes.
+func parseClaims(tokenString string, options TokenOptions, methods []string, keyFunc jwt.Keyfunc, allowLegacy bool) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(options.ClockSkew),
+	)
+	if err != nil {
+		return nil, classifyTokenError(err)
+	}
+
+	if claims.Issuer != options.Issuer && !slices.Contains(options.PreviousIssuers, claims.Issuer) {
+		return nil, classifyTokenError(jwt.ErrTokenInvalidIssuer)
+	}
+	legacy := allowLegacy && token.Method.Alg() == jwt.SigningMethodHS256.Alg() && len(claims.Audience) == 0
+	if !legacy && !slices.Contains(claims.Audience, options.Audience) {
+		return nil, classifyTokenError(jwt.ErrTokenInvalidAudience)
+	}
+	if legacy && claims.Scope == "" {
+		claims.Scope = strings.Join(SessionScopes, " ")
+	}
+	return claims, nil
+}
+
+// classifyTokenError maps jwt library errors onto our own so callers don't
+// need to import the jwt package to tell failures apart.
+func classifyTokenError(err error) error {
+	var kind error
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		kind = ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		kind = ErrTokenSignatureInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		kind = ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		kind = ErrTokenNotValidYet
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		kind = ErrTokenIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		kind = ErrTokenAudience
+	default:
+		kind = ErrTokenInvalid
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
+
+// AudienceMFAChallenge marks a token proving the password step of a login
+// succeeded. It is only accepted by the second-factor endpoint.
+const AudienceMFAChallenge = "chirpy-mfa"
+
+// Signed email links. The token names the address it was sent to, so it stops
+// working if the account's email moves on.
+const (
+	AudienceEmailVerification = "chirpy-email-verify"
+	AudienceEmailChange       = "chirpy-email-change"
+)
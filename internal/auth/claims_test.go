@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+	key, err := GenerateSigningKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	keyring := NewKeyring("LeyPhlefurapwopEitKo")
+	keyring.SetActive(key)
+	return keyring
+}
+
+func TestValidateJWTErrors(t *testing.T) {
+	keyring := newTestKeyring(t)
+	userID := uuid.New()
+
+	expired, _ := keyring.MakeJWT(Principal{UserID: userID}, -time.Hour)
+	_, err := keyring.ValidateJWT(context.Background(), expired)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	_, err = keyring.ValidateJWT(context.Background(), "invalid.token.string")
+	if !errors.Is(err, ErrTokenMalformed) {
+		t.Errorf("Expected ErrTokenMalformed, got %v", err)
+	}
+
+	// A token whose kid we know but which was signed by a different key
+	other := newTestKeyring(t)
+	forged, _ := other.MakeJWT(Principal{UserID: userID}, time.Hour)
+	keyring.Add(&SigningKey{ID: other.Active().ID, Algorithm: AlgEdDSA, Private: keyring.Active().Private})
+	_, err = keyring.ValidateJWT(context.Background(), forged)
+	if !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("Expected ErrTokenSignatureInvalid, got %v", err)
+	}
+
+	wrongAudience := newTestKeyring(t)
+	wrongAudience.SetActive(keyring.Active())
+	wrongAudience.Options.Audience = "someone-else"
+	token, _ := wrongAudience.MakeJWT(Principal{UserID: userID}, time.Hour)
+	_, err = keyring.ValidateJWT(context.Background(), token)
+	if !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("Expected ErrTokenAudience, got %v", err)
+	}
+
+	wrongIssuer := newTestKeyring(t)
+	wrongIssuer.SetActive(keyring.Active())
+	wrongIssuer.Options.Issuer = "someone-else"
+	token, _ = wrongIssuer.MakeJWT(Principal{UserID: userID}, time.Hour)
+	_, err = keyring.ValidateJWT(context.Background(), token)
+	if !errors.Is(err, ErrTokenIssuer) {
+		t.Errorf("Expected ErrTokenIssuer, got %v", err)
+	}
+
+	keyring.Options.PreviousIssuers = []string{"someone-else"}
+	_, err = keyring.ValidateJWT(context.Background(), token)
+	if err != nil {
+		t.Errorf("Expected token from a previous issuer to validate, got %v", err)
+	}
+}
+
+func TestValidateJWTClockSkew(t *testing.T) {
+	keyring := newTestKeyring(t)
+	token, _ := keyring.MakeJWT(Principal{UserID: uuid.New()}, -10*time.Second)
+
+	keyring.Options.ClockSkew = time.Minute
+	_, err := keyring.ValidateJWT(context.Background(), token)
+	if err != nil {
+		t.Errorf("Expected token within clock skew to validate, got %v", err)
+	}
+
+	keyring.Options.ClockSkew = 0
+	_, err = keyring.ValidateJWT(context.Background(), token)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestValidateJWTAlgorithmPinning(t *testing.T) {
+	keyring := newTestKeyring(t)
+	claims := newClaims(Principal{UserID: uuid.New()}, DefaultTokenOptions, time.Hour)
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err := keyring.ValidateJWT(context.Background(), none)
+	if !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("Expected ErrTokenSignatureInvalid for alg none, got %v", err)
+	}
+
+	hs384, _ := jwt.NewWithClaims(jwt.SigningMethodHS384, claims).SignedString([]byte("LeyPhlefurapwopEitKo"))
+	_, err = keyring.ValidateJWT(context.Background(), hs384)
+	if !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("Expected ErrTokenSignatureInvalid for HS384, got %v", err)
+	}
+
+	// An asymmetric token is never accepted by the HS256-only validator
+	asymmetric, _ := keyring.MakeJWT(Principal{UserID: uuid.New()}, time.Hour)
+	_, err = ValidateJWT(asymmetric, "LeyPhlefurapwopEitKo")
+	if !errors.Is(err, ErrTokenSignatureInvalid) {
+		t.Errorf("Expected ErrTokenSignatureInvalid for EdDSA token, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+const rsaKeyBits = 2048
+
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// SigningKey is an asymmetric key used to sign JWTs, identified by the kid header.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	// RetiredAt is when the key stopped signing new tokens, or zero while
+	// it is still the active key.
+	RetiredAt time.Time
+}
+
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{ID: hex.EncodeToString(buf), Algorithm: algorithm, Private: private, CreatedAt: time.Now().UTC()}, nil
+}
+
+// ParseSigningKey restores a key previously serialised with MarshalPrivateKey.
+func ParseSigningKey(id, algorithm string, der []byte, createdAt time.Time) (*SigningKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.Signer
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		if algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("key %s is Ed25519 but algorithm is %q", id, algorithm)
+		}
+		private = key
+	case *rsa.PrivateKey:
+		if algorithm != AlgRS256 {
+			return nil, fmt.Errorf("key %s is RSA but algorithm is %q", id, algorithm)
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("key %s has unsupported type %T", id, parsed)
+	}
+
+	return &SigningKey{ID: id, Algorithm: algorithm, Private: private, CreatedAt: createdAt}, nil
+}
+
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.Private)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// JWK is the public half of a SigningKey in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch public := k.Private.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+const (
+	// DefaultRetention matches the usual access token lifetime.
+	DefaultRetention = time.Hour
+	DefaultMissTTL   = time.Minute
+	// maxMisses bounds the negative cache; it is simply emptied when full.
+	maxMisses = 10000
+)
+
+// Keyring holds the active signing key plus retired keys that are still
+// accepted for verification. If a legacy HS256 secret is set, tokens signed
+// with it continue to validate so sessions survive the migration.
+type Keyring struct {
+	mu           sync.RWMutex
+	active       *SigningKey
+	keys         map[string]*SigningKey
+	misses       map[string]time.Time
+	legacySecret string
+
+	Options TokenOptions
+	// Retention is how long a retired key is trusted for, which should be
+	// at least the lifetime of the tokens it signed.
+	Retention time.Duration
+	// MissTTL is how long a kid that Fetch couldn't find is remembered, so
+	// tokens naming made-up kids don't each cost a lookup.
+	MissTTL time.Duration
+
+	// Fetch is consulted when a token names a kid the keyring doesn't hold,
+	// e.g. one rotated in by another replica.
+	Fetch func(ctx context.Context, kid string) (*SigningKey, error)
+}
+
+func NewKeyring(legacySecret string) *Keyring {
+	return &Keyring{
+		keys:         map[string]*SigningKey{},
+		misses:       map[string]time.Time{},
+		legacySecret: legacySecret,
+		Options:      DefaultTokenOptions,
+		Retention:    DefaultRetention,
+		MissTTL:      DefaultMissTTL,
+	}
+}
+
+func (k *Keyring) expired(key *SigningKey, now time.Time) bool {
+	return !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(k.Retention))
+}
+
+// Add makes a key available for verification only.
+func (k *Keyring) Add(key *SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[key.ID] = key
+	delete(k.misses, key.ID)
+}
+
+// SetActive makes a key the one new tokens are signed with. The previous
+// active key is retired from now, unless it has already been added again
+// with its retirement time.
+func (k *Keyring) SetActive(key *SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous := k.active
+	if previous != nil && previous.ID != key.ID && previous.RetiredAt.IsZero() && k.keys[previous.ID] == previous {
+		retired := *previous
+		retired.RetiredAt = time.Now().UTC()
+		k.keys[retired.ID] = &retired
+	}
+	k.keys[key.ID] = key
+	delete(k.misses, key.ID)
+	k.active = key
+}
+
+// Remove drops a retired key. The active key can't be removed.
+func (k *Keyring) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.active != nil && k.active.ID == id {
+		return
+	}
+	delete(k.keys, id)
+}
+
+// Prune removes retired keys that are past their retention, and forgets
+// expired misses.
+func (k *Keyring) Prune(now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, key := range k.keys {
+		if key != k.active && k.expired(key, now) {
+			delete(k.keys, id)
+		}
+	}
+	for kid, until := range k.misses {
+		if now.After(until) {
+			delete(k.misses, kid)
+		}
+	}
+}
+
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+func (k *Keyring) lookup(ctx context.Context, kid string) (*SigningKey, error) {
+	now := time.Now()
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	missUntil, missed := k.misses[kid]
+	k.mu.RUnlock()
+	if ok {
+		if k.expired(key, now) {
+			k.Remove(kid)
+			return nil, ErrUnknownSigningKey
+		}
+		return key, nil
+	}
+	if missed && now.Before(missUntil) {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if k.Fetch == nil {
+		return nil, ErrUnknownSigningKey
+	}
+	key, err := k.Fetch(ctx, kid)
+	if errors.Is(err, ErrUnknownSigningKey) || (err == nil && (key == nil || key.ID != kid || k.expired(key, now))) {
+		k.recordMiss(kid, now)
+		return nil, ErrUnknownSigningKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.Add(key)
+	return key, nil
+}
+
+func (k *Keyring) recordMiss(kid string, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.misses) >= maxMisses {
+		k.misses = map[string]time.Time{}
+	}
+	k.misses[kid] = now.Add(k.MissTTL)
+}
+
+// JWKS returns the public keys of every key in the ring that is still
+// trusted, newest first.
+func (k *Keyring) JWKS() JWKS {
+	now := time.Now()
+	k.mu.RLock()
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if !k.expired(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	k.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
+
+// MakeJWT issues an access token for the principal. The token ID is always
+// freshly generated.
+func (k *Keyring) MakeJWT(principal Principal, expiresIn time.Duration) (string, error) {
+	return k.MakeJWTForAudience(principal, k.Options.Audience, expiresIn)
+}
+
+// MakeJWTForAudience issues a token for some audience other than the API,
+// such as an MFA challenge, so it can't be used as an access token.
+func (k *Keyring) MakeJWTForAudience(principal Principal, audience string, expiresIn time.Duration) (string, error) {
+	key := k.Active()
+	if key == nil {
+		return "", errors.New("keyring has no active signing key")
+	}
+
+	options := k.Options
+	options.Audience = audience
+	return k.sign(key, newClaims(principal, options, expiresIn))
+}
+
+// MakeEmailJWT signs a link token tying userID to email, for one of the
+// email audiences.
+func (k *Keyring) MakeEmailJWT(userID uuid.UUID, email string, audience string, expiresIn time.Duration) (string, error) {
+	key := k.Active()
+	if key == nil {
+		return "", errors.New("keyring has no active signing key")
+	}
+
+	options := k.Options
+	options.Audience = audience
+	claims := newClaims(Principal{UserID: userID}, options, expiresIn)
+	claims.Email = email
+	return k.sign(key, claims)
+}
+
+func (k *Keyring) sign(key *SigningKey, claims *Claims) (string, error) {
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// methods lists the algorithms a token may use. Anything else, including
+// "none" and HS256 once the legacy secret is gone, is rejected before any key
+// is looked up.
+func (k *Keyring) methods() []string {
+	methods := []string{AlgEdDSA, AlgRS256}
+	if k.legacySecret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return methods
+}
+
+func (k *Keyring) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return []byte(k.legacySecret), nil
+		}
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		key, err := k.lookup(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+		}
+		return key.Private.Public(), nil
+	}
+}
+
+// ParseJWT validates a token's signature and claims and returns the claims.
+// ctx is only used if the token's key has to be fetched.
+func (k *Keyring) ParseJWT(ctx context.Context, tokenString string) (*Claims, error) {
+	return k.ParseJWTForAudience(ctx, tokenString, k.Options.Audience)
+}
+
+// ParseJWTForAudience validates a token meant for some audience other than
+// the API. Legacy HS256 tokens were only ever access tokens, so they are
+// only accepted without aud when the API's audience is asked for.
+func (k *Keyring) ParseJWTForAudience(ctx context.Context, tokenString string, audience string) (*Claims, error) {
+	options := k.Options
+	options.Audience = audience
+	return parseClaims(tokenString, options, k.methods(), k.keyFunc(ctx), audience == k.Options.Audience)
+}
+
+// Authenticate validates a token and returns the principal it was issued to.
+func (k *Keyring) Authenticate(ctx context.Context, tokenString string) (*Principal, error) {
+	claims, err := k.ParseJWT(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return claims.Principal()
+}
+
+func (k *Keyring) ValidateJWT(ctx context.Context, tokenString string) (uuid.UUID, error) {
+	claims, err := k.ParseJWT(ctx, tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	return userID, nil
+}
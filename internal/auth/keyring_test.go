@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestKeyringRoundTrip(t *testing.T) {
+	userID := uuid.MustParse("36feb268-98ca-4300-8fdd-a96bade43beb")
+
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("Error generating %s key: %v", alg, err)
+		}
+		keyring := NewKeyring("")
+		keyring.SetActive(key)
+
+		token, err := keyring.MakeJWT(Principal{UserID: userID}, time.Hour)
+		if err != nil {
+			t.Fatalf("Error creating %s JWT: %v", alg, err)
+		}
+
+		extractedID, err := keyring.ValidateJWT(context.Background(), token)
+		if err != nil {
+			t.Fatalf("Error validating %s JWT: %v", alg, err)
+		}
+		if extractedID != userID {
+			t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+		}
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	userID := uuid.New()
+	keyring := NewKeyring("")
+
+	oldKey, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.SetActive(oldKey)
+	oldToken, err := keyring.MakeJWT(Principal{UserID: userID}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating JWT: %v", err)
+	}
+
+	newKey, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.SetActive(newKey)
+
+	// Retired keys still verify
+	_, err = keyring.ValidateJWT(context.Background(), oldToken)
+	if err != nil {
+		t.Fatalf("Expected token from retired key to validate, got %v", err)
+	}
+	if len(keyring.JWKS().Keys) != 2 {
+		t.Errorf("Expected 2 keys in JWKS, got %d", len(keyring.JWKS().Keys))
+	}
+
+	// Removed keys don't
+	keyring.Remove(oldKey.ID)
+	_, err = keyring.ValidateJWT(context.Background(), oldToken)
+	if err == nil {
+		t.Error("Expected error for token from removed key, got nil")
+	}
+
+	// Unless they can be fetched
+	keyring.Fetch = func(ctx context.Context, kid string) (*SigningKey, error) {
+		return oldKey, nil
+	}
+	_, err = keyring.ValidateJWT(context.Background(), oldToken)
+	if err != nil {
+		t.Fatalf("Expected token from fetched key to validate, got %v", err)
+	}
+}
+
+func TestKeyringUnknownKeyCache(t *testing.T) {
+	keyring := NewKeyring("")
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.SetActive(key)
+	token, _ := keyring.MakeJWT(Principal{UserID: uuid.New()}, time.Hour)
+
+	other := NewKeyring("")
+	unknownKey, _ := GenerateSigningKey(AlgEdDSA)
+	other.SetActive(unknownKey)
+	unknownToken, _ := other.MakeJWT(Principal{UserID: uuid.New()}, time.Hour)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	fetches := 0
+	keyring.Fetch = func(fetchCtx context.Context, kid string) (*SigningKey, error) {
+		fetches++
+		if fetchCtx.Value(ctxKey{}) != "request" {
+			t.Errorf("Expected Fetch to get the caller's context")
+		}
+		return nil, ErrUnknownSigningKey
+	}
+
+	for range 3 {
+		_, err := keyring.ValidateJWT(ctx, unknownToken)
+		if err == nil {
+			t.Fatal("Expected error for token from unknown key, got nil")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected an unknown kid to be fetched once, got %d", fetches)
+	}
+
+	// Adding the key clears the miss
+	keyring.Add(unknownKey)
+	_, err := keyring.ValidateJWT(ctx, unknownToken)
+	if err != nil {
+		t.Errorf("Expected token from added key to validate, got %v", err)
+	}
+
+	// Misses expire
+	keyring.MissTTL = 0
+	keyring.Remove(unknownKey.ID)
+	keyring.ValidateJWT(ctx, unknownToken)
+	time.Sleep(time.Millisecond)
+	keyring.ValidateJWT(ctx, unknownToken)
+	if fetches != 3 {
+		t.Errorf("Expected expired misses to be fetched again, got %d fetches", fetches)
+	}
+
+	_, err = keyring.ValidateJWT(ctx, token)
+	if err != nil {
+		t.Errorf("Expected token from active key to validate, got %v", err)
+	}
+}
+
+func TestKeyringRetention(t *testing.T) {
+	userID := uuid.New()
+	keyring := NewKeyring("")
+	keyring.Retention = time.Minute
+
+	oldKey, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.SetActive(oldKey)
+	oldToken, _ := keyring.MakeJWT(Principal{UserID: userID}, time.Hour)
+
+	// Retired keys past their retention aren't trusted or published
+	retired := *oldKey
+	retired.RetiredAt = time.Now().Add(-2 * time.Minute)
+	newKey, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.Add(&retired)
+	keyring.SetActive(newKey)
+
+	if len(keyring.JWKS().Keys) != 1 {
+		t.Errorf("Expected 1 key in JWKS, got %d", len(keyring.JWKS().Keys))
+	}
+	_, err := keyring.ValidateJWT(context.Background(), oldToken)
+	if err == nil {
+		t.Error("Expected error for token from expired key, got nil")
+	}
+
+	// Keys retired by SetActive are kept for the retention period
+	newerKey, _ := GenerateSigningKey(AlgEdDSA)
+	keyring.SetActive(newerKey)
+	keyring.Prune(time.Now())
+	if len(keyring.JWKS().Keys) != 2 {
+		t.Errorf("Expected 2 keys in JWKS, got %d", len(keyring.JWKS().Keys))
+	}
+	keyring.Prune(time.Now().Add(2 * time.Minute))
+	if len(keyring.JWKS().Keys) != 1 || keyring.JWKS().Keys[0].KeyID != newerKey.ID {
+		t.Errorf("Expected only the active key after pruning, got %v", keyring.JWKS().Keys)
+	}
+}
+
+func TestKeyringLegacySecret(t *testing.T) {
+	userID := uuid.New()
+	secret := "LeyPhlefurapwopEitKo"
+	legacyToken, err := MakeJWT(userID, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating JWT: %v", err)
+	}
+
+	key, _ := GenerateSigningKey(AlgEdDSA)
+
+	withSecret := NewKeyring(secret)
+	withSecret.SetActive(key)
+	extractedID, err := withSecret.ValidateJWT(context.Background(), legacyToken)
+	if err != nil {
+		t.Fatalf("Error validating legacy JWT: %v", err)
+	}
+	if extractedID != userID {
+		t.Errorf("Expected user ID %v, got %v", userID, extractedID)
+	}
+
+	withoutSecret := NewKeyring("")
+	withoutSecret.SetActive(key)
+	_, err = withoutSecret.ValidateJWT(context.Background(), legacyToken)
+	if err == nil {
+		t.Error("Expected error for legacy token without secret, got nil")
+	}
+}
+
+func TestKeyringBaselineToken(t *testing.T) {
+	userID := uuid.New()
+	secret := "LeyPhlefurapwopEitKo"
+	// Tokens from before audiences and scopes had only these claims.
+	now := time.Now().UTC()
+	baselineToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer: "chirpy", Subject: userID.String(),
+		IssuedAt: &jwt.NumericDate{Time: now}, ExpiresAt: &jwt.NumericDate{Time: now.Add(time.Hour)},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Error creating JWT: %v", err)
+	}
+
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	keyring := NewKeyring(secret)
+	keyring.SetActive(key)
+
+	principal, err := keyring.Authenticate(context.Background(), baselineToken)
+	if err != nil {
+		t.Fatalf("Error validating baseline JWT: %v", err)
+	}
+	if principal.UserID != userID || !principal.HasScope(ScopeAccountManage) {
+		t.Errorf("Expected a session for %v, got %+v", userID, principal)
+	}
+
+	_, err = keyring.ParseJWTForAudience(context.Background(), baselineToken, AudienceMFAChallenge)
+	if !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("Expected a baseline token to be rejected as an MFA challenge, got %v", err)
+	}
+
+	// Tokens from the new keys must always name their audience.
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{
+		Issuer: "chirpy", Subject: userID.String(),
+		IssuedAt: &jwt.NumericDate{Time: now}, ExpiresAt: &jwt.NumericDate{Time: now.Add(time.Hour)},
+	})
+	token.Header["kid"] = key.ID
+	tokenString, _ := token.SignedString(key.Private)
+	_, err = keyring.Authenticate(context.Background(), tokenString)
+	if !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("Expected an asymmetric token without aud to be rejected, got %v", err)
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		key, _ := GenerateSigningKey(alg)
+		der, err := key.MarshalPrivateKey()
+		if err != nil {
+			t.Fatalf("Error marshalling %s key: %v", alg, err)
+		}
+
+		parsed, err := ParseSigningKey(key.ID, alg, der, key.CreatedAt)
+		if err != nil {
+			t.Fatalf("Error parsing %s key: %v", alg, err)
+		}
+		if parsed.JWK() != key.JWK() {
+			t.Errorf("Expected JWK %v, got %v", key.JWK(), parsed.JWK())
+		}
+	}
+
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	der, _ := key.MarshalPrivateKey()
+	_, err := ParseSigningKey(key.ID, AlgRS256, der, key.CreatedAt)
+	if err == nil {
+		t.Error("Expected error for mismatched algorithm, got nil")
+	}
+}
+
+func TestKeyringAuthenticate(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	keyring := NewKeyring("")
+	keyring.SetActive(key)
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	principal := Principal{UserID: uuid.New(), Roles: []string{RoleAdmin}, Scopes: []string{"a", "b"}, ClientID: "chirpy_client_1", AuthTime: authTime}
+	token, err := keyring.MakeJWT(principal, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating JWT: %v", err)
+	}
+
+	authenticated, err := keyring.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Error authenticating JWT: %v", err)
+	}
+	if authenticated.UserID != principal.UserID || !authenticated.HasRole(RoleAdmin) || !authenticated.HasScope("b") {
+		t.Errorf("Expected principal %v, got %v", principal, authenticated)
+	}
+	if authenticated.TokenID == "" {
+		t.Error("Expected token ID, got empty string")
+	}
+	if authenticated.ClientID != principal.ClientID {
+		t.Errorf("Expected client ID %s, got %q", principal.ClientID, authenticated.ClientID)
+	}
+	if !authenticated.AuthTime.Equal(authTime) {
+		t.Errorf("Expected auth time %v, got %v", authTime, authenticated.AuthTime)
+	}
+}
+
+func TestKeyringEmailJWT(t *testing.T) {
+	key, _ := GenerateSigningKey(AlgEdDSA)
+	keyring := NewKeyring("")
+	keyring.SetActive(key)
+
+	userID := uuid.New()
+	token, err := keyring.MakeEmailJWT(userID, "walt@breakingbad.com", AudienceEmailVerification, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating email JWT: %v", err)
+	}
+
+	claims, err := keyring.ParseJWTForAudience(context.Background(), token, AudienceEmailVerification)
+	if err != nil {
+		t.Fatalf("Error parsing email JWT: %v", err)
+	}
+	if claims.Email != "walt@breakingbad.com" {
+		t.Errorf("Expected email walt@breakingbad.com, got %q", claims.Email)
+	}
+
+	// An email link must not work as an access token or for another flow
+	_, err = keyring.Authenticate(context.Background(), token)
+	if !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("Expected ErrTokenAudience for API use, got %v", err)
+	}
+	_, err = keyring.ParseJWTForAudience(context.Background(), token, AudienceEmailChange)
+	if !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("Expected ErrTokenAudience for email change, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params keeps the tests fast; they are far too weak for real use.
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHasherArgon2(t *testing.T) {
+	hasher, err := NewPasswordHasher(testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Expected PHC-formatted argon2id hash, got %q", hash)
+	}
+	other, _ := hasher.Hash("correct horse battery staple")
+	if other == hash {
+		t.Error("Expected a fresh salt for each hash")
+	}
+
+	needsRehash, err := hasher.Verify("correct horse battery staple", hash)
+	if err != nil || needsRehash {
+		t.Errorf("Expected match without rehash, got %v, %v", needsRehash, err)
+	}
+	_, err = hasher.Verify("Correct horse battery staple", hash)
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Expected ErrPasswordMismatch, got %v", err)
+	}
+}
+
+func TestPasswordHasherLongPassword(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+
+	// bcrypt only looks at the first 72 bytes; argon2id uses all of them.
+	long := strings.Repeat("a", 72)
+	hash, err := hasher.Hash(long + "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = hasher.Verify(long+"c", hash)
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Expected ErrPasswordMismatch, got %v", err)
+	}
+}
+
+func TestPasswordHasherRehash(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	needsRehash, err := hasher.Verify("password", string(legacy))
+	if err != nil || !needsRehash {
+		t.Errorf("Expected bcrypt hash to match and need a rehash, got %v, %v", needsRehash, err)
+	}
+	_, err = hasher.Verify("wrong", string(legacy))
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Expected ErrPasswordMismatch, got %v", err)
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	upgraded, _ := NewPasswordHasher(stronger)
+	hash, _ := hasher.Hash("password")
+	needsRehash, err = upgraded.Verify("password", hash)
+	if err != nil || !needsRehash {
+		t.Errorf("Expected weaker argon2id hash to need a rehash, got %v, %v", needsRehash, err)
+	}
+}
+
+func TestPasswordHasherMalformed(t *testing.T) {
+	hasher, _ := NewPasswordHasher(testArgon2Params)
+
+	tests := map[string]error{
+		"":                                   ErrUnknownHashFormat,
+		"plaintext":                          ErrUnknownHashFormat,
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA": ErrMalformedHash,
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5": ErrMalformedHash,
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5":  ErrMalformedHash,
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5":         ErrMalformedHash,
+	}
+	for hash, expected := range tests {
+		_, err := hasher.Verify("password", hash)
+		if !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, hash, err)
+		}
+	}
+
+	_, err := NewPasswordHasher(Argon2Params{Memory: 1, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if !errors.Is(err, ErrInvalidArgonParams) {
+		t.Errorf("Expected ErrInvalidArgonParams, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+const RoleAdmin = "admin"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID  uuid.UUID
+	Roles   []string
+	Scopes  []string
+	TokenID string
+	// ClientID is set when a third-party OAuth client acts for the user.
+	ClientID string
+	// AuthTime is when the user last logged in, if the token says.
+	AuthTime time.Time
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware,
+// or false if the request is anonymous.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok
+}
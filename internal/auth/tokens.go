@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead    = "chirps:read"
+	ScopeChirpsWrite   = "chirps:write"
+	ScopeProfileWrite  = "profile:write"
+	ScopeTokensManage  = "tokens:manage"
+	ScopeAccountManage = "account:manage"
+)
+
+// SessionScopes are granted to users who log in interactively.
+var SessionScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite, ScopeTokensManage, ScopeAccountManage}
+
+// PersonalAccessTokenScopes may be granted to personal access tokens. A token
+// can't be used to mint more tokens.
+var PersonalAccessTokenScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+// OAuthScopes may be requested by third-party OAuth clients.
+var OAuthScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite}
+
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+// MakePersonalAccessToken returns a new token along with a short prefix that
+// is safe to store and show so users can tell their tokens apart.
+func MakePersonalAccessToken() (token string, displayPrefix string, err error) {
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = personalAccessTokenPrefix + hex.EncodeToString(buf)
+	return token, token[:len(personalAccessTokenPrefix)+8], nil
+}
+
+// MakeOpaqueToken returns a random token for single-use links such as
+// password resets. Store it with HashToken.
+func MakeOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
+
+// HashToken returns the hash under which an opaque token is stored. Tokens
+// are high entropy, so an unsalted hash is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ValidateScopes(scopes []string, allowed []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
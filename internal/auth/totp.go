@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"image/png"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod         = 30
+	totpSkew           = 1
+	totpQRCodeSize     = 256
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 12
+)
+
+type TOTPKey struct {
+	Secret string
+	URI    string
+}
+
+func GenerateTOTPKey(issuer, accountName string) (*TOTPKey, error) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: issuer, AccountName: accountName, Period: totpPeriod})
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPKey{Secret: key.Secret(), URI: key.URL()}, nil
+}
+
+// TOTPQRCode renders an otpauth:// URI as a PNG for authenticator apps to scan.
+func TOTPQRCode(uri string) ([]byte, error) {
+	key, err := otp.NewKeyFromURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	img, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ValidateTOTPCode checks a code against the time steps either side of now,
+// ignoring steps at or before lastStep so a code can't be replayed. It returns
+// the step that matched, which the caller should record as the new lastStep.
+func ValidateTOTPCode(code, secret string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// MakeRecoveryCodes returns one-time codes for when the authenticator is lost.
+// Store them with HashToken after normalising with NormalizeRecoveryCode.
+func MakeRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		buf := make([]byte, 8)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf)[:recoveryCodeLength])
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+	}
+	return codes, nil
+}
+
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"github.com/pquerna/otp/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTPCode(t *testing.T) {
+	key, err := GenerateTOTPKey("Chirpy", "walt@breakingbad.com")
+	if err != nil {
+		t.Fatalf("Error generating TOTP key: %v", err)
+	}
+	if !strings.HasPrefix(key.URI, "otpauth://totp/") {
+		t.Errorf("Expected otpauth URI, got %q", key.URI)
+	}
+
+	now := time.Now()
+	code, err := totp.GenerateCode(key.Secret, now)
+	if err != nil {
+		t.Fatalf("Error generating code: %v", err)
+	}
+
+	step, ok := ValidateTOTPCode(code, key.Secret, now, 0)
+	if !ok {
+		t.Fatal("Expected current code to validate")
+	}
+
+	// The same code can't be used twice
+	_, ok = ValidateTOTPCode(code, key.Secret, now, step)
+	if ok {
+		t.Error("Expected replayed code to be rejected")
+	}
+
+	// Codes from the previous step are still accepted, older ones aren't
+	_, ok = ValidateTOTPCode(code, key.Secret, now.Add(30*time.Second), 0)
+	if !ok {
+		t.Error("Expected code from previous step to validate")
+	}
+	_, ok = ValidateTOTPCode(code, key.Secret, now.Add(5*time.Minute), 0)
+	if ok {
+		t.Error("Expected stale code to be rejected")
+	}
+}
+
+func TestTOTPQRCode(t *testing.T) {
+	key, _ := GenerateTOTPKey("Chirpy", "walt@breakingbad.com")
+	png, err := TOTPQRCode(key.URI)
+	if err != nil {
+		t.Fatalf("Error rendering QR code: %v", err)
+	}
+	if !strings.HasPrefix(string(png), "\x89PNG") {
+		t.Error("Expected PNG data")
+	}
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes()
+	if err != nil {
+		t.Fatalf("Error creating recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("Expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		normalized := NormalizeRecoveryCode(strings.ToUpper(" " + code + " "))
+		if len(normalized) != recoveryCodeLength || seen[normalized] {
+			t.Errorf("Unexpected recovery code %q", code)
+		}
+		seen[normalized] = true
+	}
+}
@@ -0,0 +1,69 @@
+// Package billing abstracts the payment providers that sell Chirpy
+// memberships. Each provider verifies and parses its own webhooks into
+// Events, and creates hosted checkout sessions for the configured plans.
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Event types, as normalised from each provider's own names. Events of any
+// other type are passed through with the provider's name for them.
+const (
+	EventSubscriptionActivated = "subscription.activated"
+	EventSubscriptionRenewed   = "subscription.renewed"
+	EventSubscriptionCanceled  = "subscription.canceled"
+	EventPaymentFailed         = "payment.failed"
+)
+
+var (
+	ErrMalformedEvent      = errors.New("malformed billing event")
+	ErrWebhookSecretUnset  = errors.New("webhook secret is not configured")
+	ErrCheckoutUnavailable = errors.New("checkout is not configured for this provider")
+	ErrPlanUnavailable     = errors.New("plan is not sold through this provider")
+)
+
+// Event is a billing webhook in provider-neutral form.
+type Event struct {
+	ID string `json:"id"`
+	// Type is one of the Event constants, or the provider's own name for
+	// events Chirpy doesn't act on.
+	Type string `json:"type"`
+	// ProviderType is the event name as the provider sent it.
+	ProviderType string `json:"provider_type"`
+	UserID       string `json:"user_id"`
+	// PlanID and PriceID identify the plan, by Chirpy's ID or the
+	// provider's price ID, when the provider includes one.
+	PlanID           string     `json:"plan_id,omitempty"`
+	PriceID          string     `json:"price_id,omitempty"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end,omitempty"`
+}
+
+type CheckoutRequest struct {
+	UserID     string
+	Email      string
+	Plan       Plan
+	SuccessURL string
+	CancelURL  string
+}
+
+// CheckoutSession is a provider-hosted payment page the user is sent to.
+type CheckoutSession struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Provider is a payment provider. Name is used in webhook URLs, stored
+// events and plan price IDs, so it must not change once in use.
+type Provider interface {
+	Name() string
+	// VerifyWebhook checks that a delivery was sent by the provider.
+	VerifyWebhook(header http.Header, body []byte, now time.Time) error
+	// ParseEvent decodes a verified delivery.
+	ParseEvent(body []byte) (Event, error)
+	CreateCheckoutSession(ctx context.Context, request CheckoutRequest) (*CheckoutSession, error)
+}
@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/webhook"
+	"sync"
+	"time"
+)
+
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Fake is an in-memory provider for tests and local development. Its
+// webhooks are Events encoded as JSON, signed the same way as Polka's, and
+// its checkout sessions send the user straight to the success URL.
+type Fake struct {
+	Secret string
+
+	mu       sync.Mutex
+	requests []CheckoutRequest
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) VerifyWebhook(header http.Header, body []byte, now time.Time) error {
+	if f.Secret == "" {
+		return ErrWebhookSecretUnset
+	}
+	return webhook.Verify(f.Secret, header.Get(FakeSignatureHeader), body, now, webhook.DefaultTolerance)
+}
+
+func (f *Fake) ParseEvent(body []byte) (Event, error) {
+	event := Event{}
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	if event.ID == "" || event.Type == "" {
+		return Event{}, fmt.Errorf("%w: id and type are required", ErrMalformedEvent)
+	}
+	if event.ProviderType == "" {
+		event.ProviderType = event.Type
+	}
+	return event, nil
+}
+
+// SignEvent encodes an event as a webhook delivery sent at now.
+func (f *Fake) SignEvent(event Event, now time.Time) ([]byte, http.Header, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, webhook.Sign(f.Secret, now, body))
+	return body, header, nil
+}
+
+func (f *Fake) CreateCheckoutSession(ctx context.Context, request CheckoutRequest) (*CheckoutSession, error) {
+	if _, ok := request.Plan.PriceIDs[f.Name()]; !ok {
+		return nil, ErrPlanUnavailable
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request)
+	id := fmt.Sprintf("cs_fake_%d", len(f.requests))
+
+	successURL, err := url.Parse(request.SuccessURL)
+	if err != nil {
+		return nil, err
+	}
+	query := successURL.Query()
+	query.Set("session_id", id)
+	successURL.RawQuery = query.Encode()
+
+	return &CheckoutSession{ID: id, URL: successURL.String(), ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+// CheckoutRequests returns the checkout sessions created so far.
+func (f *Fake) CheckoutRequests() []CheckoutRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]CheckoutRequest(nil), f.requests...)
+}
@@ -0,0 +1,55 @@
+package billing
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeEventRoundTrip(t *testing.T) {
+	fake := &Fake{Secret: "whsec_fake"}
+	now := time.Now()
+	sent := Event{ID: "evt_1", Type: EventPaymentFailed, UserID: "u1"}
+
+	body, header, err := fake.SignEvent(sent, now)
+	if err != nil {
+		t.Fatalf("Error signing event: %v", err)
+	}
+	err = fake.VerifyWebhook(header, body, now)
+	if err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+	received, err := fake.ParseEvent(body)
+	if err != nil {
+		t.Fatalf("Error parsing event: %v", err)
+	}
+	if received.ID != sent.ID || received.Type != sent.Type || received.ProviderType != sent.Type || received.UserID != sent.UserID {
+		t.Errorf("Expected %+v, got %+v", sent, received)
+	}
+
+	err = (&Fake{Secret: "other"}).VerifyWebhook(header, body, now)
+	if err == nil {
+		t.Errorf("Expected signature from another secret to be rejected")
+	}
+}
+
+func TestFakeCreateCheckoutSession(t *testing.T) {
+	fake := &Fake{}
+	plan := Plan{ID: "red", PriceIDs: map[string]string{"fake": "price_red"}}
+
+	session, err := fake.CreateCheckoutSession(context.Background(), CheckoutRequest{
+		UserID: "u1", Plan: plan, SuccessURL: "https://chirpy.test/billing?done=1",
+	})
+	if err != nil {
+		t.Fatalf("Error creating checkout session: %v", err)
+	}
+	if !strings.HasPrefix(session.URL, "https://chirpy.test/billing?") || !strings.Contains(session.URL, "session_id="+session.ID) {
+		t.Errorf("Expected the session to redirect to the success URL, got %q", session.URL)
+	}
+
+	requests := fake.CheckoutRequests()
+	if len(requests) != 1 || requests[0].UserID != "u1" {
+		t.Errorf("Expected the checkout request to be recorded, got %+v", requests)
+	}
+}
@@ -0,0 +1,102 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Plan is a membership Chirpy sells. Prices are in the currency's minor
+// unit, and PriceIDs maps each provider's name to its ID for the price.
+type Plan struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Price      int64             `json:"price"`
+	Currency   string            `json:"currency"`
+	PeriodDays int               `json:"period_days"`
+	PriceIDs   map[string]string `json:"price_ids"`
+}
+
+// Period is how long one payment pays for.
+func (p Plan) Period() time.Duration {
+	return time.Duration(p.PeriodDays) * 24 * time.Hour
+}
+
+// DefaultPlans is used when no plans file is configured.
+var DefaultPlans = []Plan{{
+	ID:         "red",
+	Name:       "Chirpy Red",
+	Price:      499,
+	Currency:   "USD",
+	PeriodDays: 30,
+	PriceIDs:   map[string]string{"polka": "price_chirpy_red_monthly", "fake": "price_fake_red"},
+}}
+
+// LoadPlans reads a JSON array of plans. See ReadPlans.
+func LoadPlans(path string) ([]Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadPlans(file)
+}
+
+// ReadPlans reads and checks a JSON array of plans. The first plan is the
+// default for memberships whose events don't say which plan they are on.
+func ReadPlans(reader io.Reader) ([]Plan, error) {
+	plans := []Plan{}
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&plans)
+	if err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("no plans defined")
+	}
+
+	seen := map[string]bool{}
+	for i, plan := range plans {
+		switch {
+		case plan.ID == "":
+			return nil, fmt.Errorf("plan %d: id is required", i)
+		case seen[plan.ID]:
+			return nil, fmt.Errorf("plan %s: duplicate id", plan.ID)
+		case plan.Price < 0:
+			return nil, fmt.Errorf("plan %s: price must not be negative", plan.ID)
+		case !currencyPattern.MatchString(plan.Currency):
+			return nil, fmt.Errorf("plan %s: currency must be an ISO 4217 code", plan.ID)
+		case plan.PeriodDays <= 0:
+			return nil, fmt.Errorf("plan %s: period_days must be positive", plan.ID)
+		}
+		seen[plan.ID] = true
+	}
+	return plans, nil
+}
+
+// FindPlan returns the plan with the given ID.
+func FindPlan(plans []Plan, id string) (Plan, bool) {
+	for _, plan := range plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// FindPlanByPrice returns the plan a provider's price ID belongs to.
+func FindPlanByPrice(plans []Plan, provider string, priceID string) (Plan, bool) {
+	for _, plan := range plans {
+		if priceID != "" && plan.PriceIDs[provider] == priceID {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
@@ -0,0 +1,59 @@
+package billing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPlans(t *testing.T) {
+	plans, err := ReadPlans(strings.NewReader(`[
+		{"id": "red", "name": "Chirpy Red", "price": 499, "currency": "USD", "period_days": 30, "price_ids": {"polka": "price_1"}},
+		{"id": "red_yearly", "name": "Chirpy Red (yearly)", "price": 4999, "currency": "USD", "period_days": 365, "price_ids": {"polka": "price_2"}}
+	]`))
+	if err != nil {
+		t.Fatalf("Error reading plans: %v", err)
+	}
+	if len(plans) != 2 {
+		t.Fatalf("Expected 2 plans, got %d", len(plans))
+	}
+	if plans[1].Period() != 365*24*time.Hour {
+		t.Errorf("Expected a 365 day period, got %v", plans[1].Period())
+	}
+
+	plan, ok := FindPlanByPrice(plans, "polka", "price_2")
+	if !ok || plan.ID != "red_yearly" {
+		t.Errorf("Expected price_2 to find red_yearly, got %q, %v", plan.ID, ok)
+	}
+	_, ok = FindPlanByPrice(plans, "fake", "price_2")
+	if ok {
+		t.Errorf("Expected price IDs to be per provider")
+	}
+	_, ok = FindPlan(plans, "blue")
+	if ok {
+		t.Errorf("Expected no plan called blue")
+	}
+}
+
+func TestReadPlansRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", `[]`},
+		{"missing id", `[{"name": "Red", "price": 1, "currency": "USD", "period_days": 30}]`},
+		{"duplicate id", `[{"id": "red", "currency": "USD", "period_days": 30}, {"id": "red", "currency": "USD", "period_days": 30}]`},
+		{"negative price", `[{"id": "red", "price": -1, "currency": "USD", "period_days": 30}]`},
+		{"bad currency", `[{"id": "red", "price": 1, "currency": "dollars", "period_days": 30}]`},
+		{"no period", `[{"id": "red", "price": 1, "currency": "USD"}]`},
+		{"unknown field", `[{"id": "red", "price": 1, "currency": "USD", "period_days": 30, "colour": "red"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPlans(strings.NewReader(tt.input))
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/webhook"
+	"strings"
+	"time"
+)
+
+const PolkaSignatureHeader = "X-Polka-Signature"
+
+var polkaEventTypes = map[string]string{
+	"user.upgraded":        EventSubscriptionActivated,
+	"subscription.renewed": EventSubscriptionRenewed,
+	"user.downgraded":      EventSubscriptionCanceled,
+	"payment.failed":       EventPaymentFailed,
+}
+
+// Polka is the Polka payment service. Its webhooks are signed as described
+// in the webhook package.
+type Polka struct {
+	WebhookSecret string
+	// APIKey and BaseURL are only needed to create checkout sessions.
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+func (p *Polka) Name() string {
+	return "polka"
+}
+
+func (p *Polka) VerifyWebhook(header http.Header, body []byte, now time.Time) error {
+	if p.WebhookSecret == "" {
+		return ErrWebhookSecretUnset
+	}
+	return webhook.Verify(p.WebhookSecret, header.Get(PolkaSignatureHeader), body, now, webhook.DefaultTolerance)
+}
+
+func (p *Polka) ParseEvent(body []byte) (Event, error) {
+	payload := struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  struct {
+			UserID           string     `json:"user_id"`
+			Plan             string     `json:"plan"`
+			PriceID          string     `json:"price_id"`
+			CurrentPeriodEnd *time.Time `json:"current_period_end"`
+		} `json:"data"`
+	}{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	if payload.ID == "" || payload.Event == "" {
+		return Event{}, fmt.Errorf("%w: id and event are required", ErrMalformedEvent)
+	}
+
+	eventType, ok := polkaEventTypes[payload.Event]
+	if !ok {
+		eventType = payload.Event
+	}
+	return Event{
+		ID:               payload.ID,
+		Type:             eventType,
+		ProviderType:     payload.Event,
+		UserID:           payload.Data.UserID,
+		PlanID:           payload.Data.Plan,
+		PriceID:          payload.Data.PriceID,
+		CurrentPeriodEnd: payload.Data.CurrentPeriodEnd,
+	}, nil
+}
+
+// CreateCheckoutSession asks Polka for a hosted checkout page. The user ID
+// is passed as the client reference, and comes back in the webhooks for the
+// resulting subscription.
+func (p *Polka) CreateCheckoutSession(ctx context.Context, request CheckoutRequest) (*CheckoutSession, error) {
+	if p.APIKey == "" || p.BaseURL == "" {
+		return nil, ErrCheckoutUnavailable
+	}
+	priceID, ok := request.Plan.PriceIDs[p.Name()]
+	if !ok {
+		return nil, ErrPlanUnavailable
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"price_id":            priceID,
+		"client_reference_id": request.UserID,
+		"customer_email":      request.Email,
+		"success_url":         request.SuccessURL,
+		"cancel_url":          request.CancelURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.BaseURL, "/")+"/v1/checkout/sessions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Authorization", "Bearer "+p.APIKey)
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("polka returned %s creating a checkout session", response.Status)
+	}
+
+	session := &CheckoutSession{}
+	err = json.NewDecoder(response.Body).Decode(session)
+	if err != nil {
+		return nil, err
+	}
+	if session.ID == "" || session.URL == "" {
+		return nil, fmt.Errorf("polka returned an incomplete checkout session")
+	}
+	return session, nil
+}
@@ -0,0 +1,100 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"pjh.id.au/chirpy/v2/internal/webhook"
+	"testing"
+	"time"
+)
+
+func TestPolkaVerifyWebhook(t *testing.T) {
+	polka := &Polka{WebhookSecret: "whsec_test"}
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"u1"}}`)
+	now := time.Unix(1700000000, 0)
+
+	header := http.Header{}
+	header.Set(PolkaSignatureHeader, webhook.Sign("whsec_test", now, body))
+	err := polka.VerifyWebhook(header, body, now)
+	if err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	err = (&Polka{}).VerifyWebhook(header, body, now)
+	if !errors.Is(err, ErrWebhookSecretUnset) {
+		t.Errorf("Expected ErrWebhookSecretUnset without a secret, got %v", err)
+	}
+}
+
+func TestPolkaParseEvent(t *testing.T) {
+	polka := &Polka{}
+	event, err := polka.ParseEvent([]byte(`{"id":"evt_1","event":"user.downgraded","data":{"user_id":"u1"}}`))
+	if err != nil {
+		t.Fatalf("Error parsing event: %v", err)
+	}
+	if event.Type != EventSubscriptionCanceled || event.ProviderType != "user.downgraded" || event.UserID != "u1" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	event, err = polka.ParseEvent([]byte(`{"id":"evt_2","event":"subscription.renewed","data":{"user_id":"u1","price_id":"price_1","current_period_end":"2030-01-02T03:04:05Z"}}`))
+	if err != nil {
+		t.Fatalf("Error parsing event: %v", err)
+	}
+	if event.Type != EventSubscriptionRenewed || event.PriceID != "price_1" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if event.CurrentPeriodEnd == nil || !event.CurrentPeriodEnd.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected period end %v", event.CurrentPeriodEnd)
+	}
+
+	event, err = polka.ParseEvent([]byte(`{"id":"evt_3","event":"invoice.created","data":{}}`))
+	if err != nil || event.Type != "invoice.created" {
+		t.Errorf("Expected unknown events to pass through, got %+v, %v", event, err)
+	}
+
+	_, err = polka.ParseEvent([]byte(`{"event":"user.upgraded"}`))
+	if !errors.Is(err, ErrMalformedEvent) {
+		t.Errorf("Expected ErrMalformedEvent without an id, got %v", err)
+	}
+}
+
+func TestPolkaCreateCheckoutSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/v1/checkout/sessions" || request.Header.Get("Authorization") != "Bearer sk_test" {
+			http.Error(writer, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		params := map[string]string{}
+		json.NewDecoder(request.Body).Decode(&params)
+		if params["price_id"] != "price_1" || params["client_reference_id"] != "u1" {
+			http.Error(writer, "unexpected params", http.StatusBadRequest)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write([]byte(`{"id":"cs_1","url":"https://pay.example/cs_1","expires_at":"2030-01-01T00:00:00Z"}`))
+	}))
+	defer server.Close()
+
+	polka := &Polka{APIKey: "sk_test", BaseURL: server.URL}
+	plan := Plan{ID: "red", PriceIDs: map[string]string{"polka": "price_1"}}
+	session, err := polka.CreateCheckoutSession(context.Background(), CheckoutRequest{UserID: "u1", Plan: plan})
+	if err != nil {
+		t.Fatalf("Error creating checkout session: %v", err)
+	}
+	if session.ID != "cs_1" || session.URL != "https://pay.example/cs_1" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+
+	_, err = polka.CreateCheckoutSession(context.Background(), CheckoutRequest{UserID: "u1", Plan: Plan{ID: "blue"}})
+	if !errors.Is(err, ErrPlanUnavailable) {
+		t.Errorf("Expected ErrPlanUnavailable for a plan without a Polka price, got %v", err)
+	}
+
+	_, err = (&Polka{}).CreateCheckoutSession(context.Background(), CheckoutRequest{UserID: "u1", Plan: plan})
+	if !errors.Is(err, ErrCheckoutUnavailable) {
+		t.Errorf("Expected ErrCheckoutUnavailable without an API key, got %v", err)
+	}
+}
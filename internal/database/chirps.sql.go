@@ -7,10 +7,30 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const countChirpsByAuthorSince = `-- name: CountChirpsByAuthorSince :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1
+  AND created_at >= $2
+`
+
+type CountChirpsByAuthorSinceParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) CountChirpsByAuthorSince(ctx context.Context, arg CountChirpsByAuthorSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthorSince, arg.UserID, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
 INSERT INTO chirps (id, created_at, updated_at, body, user_id)
 VALUES (gen_random_uuid(),
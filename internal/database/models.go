@@ -6,11 +6,19 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type Avatar struct {
+	UserID      uuid.UUID
+	UpdatedAt   time.Time
+	ContentType string
+	Data        []byte
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,6 +27,116 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
+type DataExport struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UserID      uuid.UUID
+	Status      string
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	TokenHash   sql.NullString
+	ExpiresAt   sql.NullTime
+	LastError   sql.NullString
+}
+
+type DataExportArchive struct {
+	ExportID uuid.UUID
+	Data     []byte
+}
+
+type EmailOutbox struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	Recipient     string
+	Subject       string
+	Body          string
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	SentAt        sql.NullTime
+}
+
+type MagicLinkToken struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+	FamilyID      uuid.UUID
+}
+
+type OauthClient struct {
+	ID           string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+}
+
+type OidcLoginState struct {
+	State        string
+	CreatedAt    time.Time
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uuid.UUID
+	Name        string
+	TokenHash   string
+	TokenPrefix string
+	Scopes      []string
+	ExpiresAt   sql.NullTime
+	LastUsedAt  sql.NullTime
+	RevokedAt   sql.NullTime
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	Allowed   bool
+	UpdatedAt time.Time
+}
+
 type RefreshToken struct {
 	Token     string
 	CreatedAt time.Time
@@ -26,6 +144,36 @@ type RefreshToken struct {
 	UserID    uuid.UUID
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+	Scopes    []string
+	FamilyID  uuid.NullUUID
+}
+
+type SigningKey struct {
+	ID         string
+	CreatedAt  time.Time
+	Algorithm  string
+	PrivateKey []byte
+	RetiredAt  sql.NullTime
+}
+
+type Subscription struct {
+	UserID            uuid.UUID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Plan              string
+	Status            string
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+	CanceledAt        sql.NullTime
+	GraceUntil        sql.NullTime
+}
+
+type SubscriptionEvent struct {
+	Provider  string
+	EventID   string
+	UserID    uuid.UUID
+	AppliedAt time.Time
 }
 
 type User struct {
@@ -35,4 +183,67 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	Roles          []string
+	VerifiedAt     sql.NullTime
+	PendingEmail   sql.NullString
+	Handle         sql.NullString
+	DisplayName    string
+	Bio            string
+	AvatarUrl      string
+	Location       string
+	Website        string
+	DeleteAfter    sql.NullTime
+}
+
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Email     string
+}
+
+type UserTotp struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Secret       string
+	EnabledAt    sql.NullTime
+	LastUsedStep int64
+}
+
+type WebauthnCredential struct {
+	ID              []byte
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uuid.UUID
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+	LastUsedAt      sql.NullTime
+}
+
+type WebauthnSession struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.NullUUID
+	Ceremony  string
+	Data      json.RawMessage
+	ExpiresAt time.Time
+}
+
+type WebhookEvent struct {
+	ID          string
+	Provider    string
+	EventType   string
+	Payload     json.RawMessage
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	Attempts    int32
+	LastError   sql.NullString
 }
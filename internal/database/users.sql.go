@@ -7,27 +7,87 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET updated_at   = NOW(),
+    delete_after = NULL
+WHERE id = $1
+  AND delete_after IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const confirmUserEmailChange = `-- name: ConfirmUserEmailChange :one
+UPDATE users
+SET updated_at = NOW(),
+    email = pending_email,
+    pending_email = NULL,
+    verified_at = NOW()
+WHERE id = $1
+  AND pending_email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+`
+
+type ConfirmUserEmailChangeParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) ConfirmUserEmailChange(ctx context.Context, arg ConfirmUserEmailChangeParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmUserEmailChange, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (gen_random_uuid(),
         NOW(),
         NOW(),
         $1,
-        $2)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+        $2,
+        $3)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -36,6 +96,16 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
@@ -49,8 +119,172 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const deleteUsersPastGracePeriod = `-- name: DeleteUsersPastGracePeriod :many
+DELETE FROM users
+WHERE delete_after <= NOW()
+RETURNING id
+`
+
+func (q *Queries) DeleteUsersPastGracePeriod(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, deleteUsersPastGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuthorSummaries = `-- name: GetAuthorSummaries :many
+SELECT id, handle, display_name, avatar_url
+FROM users
+WHERE id = ANY($1::uuid[])
+  AND delete_after IS NULL
+`
+
+type GetAuthorSummariesRow struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	AvatarUrl   string
+}
+
+func (q *Queries) GetAuthorSummaries(ctx context.Context, ids []uuid.UUID) ([]GetAuthorSummariesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAuthorSummaries, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAuthorSummariesRow
+	for rows.Next() {
+		var i GetAuthorSummariesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProfile = `-- name: GetProfile :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1
+  AND delete_after IS NULL
+`
+
+// Profiles of accounts waiting to be deleted are hidden as if already gone.
+func (q *Queries) GetProfile(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getProfile, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getProfileByHandle = `-- name: GetProfileByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE lower(handle) = lower($1)
+  AND delete_after IS NULL
+`
+
+func (q *Queries) GetProfileByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getProfileByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
 FROM users
 WHERE email = $1
 `
@@ -65,17 +299,190 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
+const getUserForUpdate = `-- name: GetUserForUpdate :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetUserForUpdate(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserForUpdate, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2
+  AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHash string
+	ID      uuid.UUID
+	OldHash string
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHash, arg.ID, arg.OldHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
+UPDATE users
+SET updated_at   = NOW(),
+    delete_after = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+`
+
+type ScheduleUserDeletionParams struct {
+	ID          uuid.UUID
+	DeleteAfter sql.NullTime
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, scheduleUserDeletion, arg.ID, arg.DeleteAfter)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const setUserAvatarURL = `-- name: SetUserAvatarURL :one
+UPDATE users
+SET updated_at = NOW(),
+    avatar_url = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+`
+
+type SetUserAvatarURLParams struct {
+	ID        uuid.UUID
+	AvatarUrl string
+}
+
+func (q *Queries) SetUserAvatarURL(ctx context.Context, arg SetUserAvatarURLParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserAvatarURL, arg.ID, arg.AvatarUrl)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const setUserChirpyRed = `-- name: SetUserChirpyRed :execrows
+UPDATE users
+SET updated_at = NOW(),
+    is_chirpy_red = $2
+WHERE id = $1
+`
+
+type SetUserChirpyRedParams struct {
+	ID          uuid.UUID
+	IsChirpyRed bool
+}
+
+func (q *Queries) SetUserChirpyRed(ctx context.Context, arg SetUserChirpyRedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserChirpyRed, arg.ID, arg.IsChirpyRed)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserPendingEmail = `-- name: SetUserPendingEmail :exec
+UPDATE users
+SET updated_at = NOW(),
+    pending_email = $2
+WHERE id = $1
+`
+
+type SetUserPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetUserPendingEmail(ctx context.Context, arg SetUserPendingEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPendingEmail, arg.ID, arg.PendingEmail)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET updated_at = NOW(),
     email = $2,
     hashed_password = $3
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
 `
 
 type UpdateUserParams struct {
@@ -94,19 +501,87 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
-const upgradeUser = `-- name: UpgradeUser :execrows
+const updateUserProfile = `-- name: UpdateUserProfile :one
 UPDATE users
 SET updated_at = NOW(),
-    is_chirpy_red = true
+    handle = $2,
+    display_name = $3,
+    bio = $4,
+    location = $5,
+    website = $6
 WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
 `
 
-func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) (int64, error) {
-	result, err := q.db.ExecContext(ctx, upgradeUser, id)
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.Location,
+		arg.Website,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		pq.Array(&i.Roles),
+		&i.VerifiedAt,
+		&i.PendingEmail,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Location,
+		&i.Website,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET updated_at = NOW(),
+    verified_at = COALESCE(verified_at, NOW())
+WHERE id = $1
+  AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,93 @@
+// Package entitlements maps membership tiers to the limits and features
+// that come with them. Handlers ask what a user is entitled to rather than
+// checking their tier directly, so perks can change in one place.
+package entitlements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+type Tier string
+
+const (
+	Free Tier = "free"
+	Red  Tier = "red"
+)
+
+var (
+	ErrChirpTooLong      = errors.New("chirp is too long")
+	ErrDailyLimitReached = errors.New("daily chirp limit reached")
+)
+
+// Entitlements describes a tier. Editing, media and scheduled posts don't
+// exist yet, so their limits are only reported to clients; the rest are
+// enforced through the Check methods.
+type Entitlements struct {
+	Tier           Tier `json:"tier"`
+	MaxChirpLength int  `json:"max_chirp_length"`
+	// EditWindow is how long after posting a chirp can be edited. Zero
+	// means chirps can't be edited.
+	EditWindow       time.Duration `json:"-"`
+	MaxMediaPerChirp int           `json:"max_media_per_chirp"`
+	// DailyChirpLimit is how many chirps can be posted in any 24 hours.
+	// Zero means there is no limit.
+	DailyChirpLimit int  `json:"daily_chirp_limit"`
+	ScheduledPosts  bool `json:"scheduled_posts"`
+	AdFree          bool `json:"ad_free"`
+}
+
+var tiers = map[Tier]Entitlements{
+	Free: {
+		Tier:             Free,
+		MaxChirpLength:   140,
+		MaxMediaPerChirp: 1,
+		DailyChirpLimit:  50,
+	},
+	Red: {
+		Tier:             Red,
+		MaxChirpLength:   500,
+		EditWindow:       30 * time.Minute,
+		MaxMediaPerChirp: 4,
+		ScheduledPosts:   true,
+		AdFree:           true,
+	},
+}
+
+// For returns the entitlements of a tier. Unknown tiers get the free
+// tier's.
+func For(tier Tier) Entitlements {
+	entitlements, ok := tiers[tier]
+	if !ok {
+		return tiers[Free]
+	}
+	return entitlements
+}
+
+// MarshalJSON gives the edit window in whole seconds.
+func (e Entitlements) MarshalJSON() ([]byte, error) {
+	type plain Entitlements
+	return json.Marshal(struct {
+		plain
+		EditWindowSeconds int64 `json:"edit_window_seconds"`
+	}{plain(e), int64(e.EditWindow / time.Second)})
+}
+
+// CheckChirpLength counts characters rather than bytes.
+func (e Entitlements) CheckChirpLength(body string) error {
+	if utf8.RuneCountInString(body) > e.MaxChirpLength {
+		return fmt.Errorf("%w; the limit is %d characters", ErrChirpTooLong, e.MaxChirpLength)
+	}
+	return nil
+}
+
+// CheckDailyQuota takes the number of chirps posted in the last 24 hours.
+func (e Entitlements) CheckDailyQuota(postedToday int64) error {
+	if e.DailyChirpLimit > 0 && postedToday >= int64(e.DailyChirpLimit) {
+		return fmt.Errorf("%w; you can post %d chirps a day", ErrDailyLimitReached, e.DailyChirpLimit)
+	}
+	return nil
+}
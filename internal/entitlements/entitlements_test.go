@@ -0,0 +1,59 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	if For(Red).Tier != Red {
+		t.Errorf("Expected red entitlements for the red tier")
+	}
+	if For("gold").Tier != Free {
+		t.Errorf("Expected unknown tiers to fall back to free")
+	}
+}
+
+func TestCheckChirpLength(t *testing.T) {
+	free := For(Free)
+	if err := free.CheckChirpLength(strings.Repeat("a", free.MaxChirpLength)); err != nil {
+		t.Errorf("Expected a chirp at the limit to be allowed, got %v", err)
+	}
+	if err := free.CheckChirpLength(strings.Repeat("é", free.MaxChirpLength)); err != nil {
+		t.Errorf("Expected length to count characters, not bytes, got %v", err)
+	}
+	err := free.CheckChirpLength(strings.Repeat("a", free.MaxChirpLength+1))
+	if !errors.Is(err, ErrChirpTooLong) {
+		t.Errorf("Expected ErrChirpTooLong, got %v", err)
+	}
+	if err := For(Red).CheckChirpLength(strings.Repeat("a", free.MaxChirpLength+1)); err != nil {
+		t.Errorf("Expected red to allow longer chirps, got %v", err)
+	}
+}
+
+func TestCheckDailyQuota(t *testing.T) {
+	free := For(Free)
+	if err := free.CheckDailyQuota(int64(free.DailyChirpLimit - 1)); err != nil {
+		t.Errorf("Expected a chirp under the limit to be allowed, got %v", err)
+	}
+	if err := free.CheckDailyQuota(int64(free.DailyChirpLimit)); !errors.Is(err, ErrDailyLimitReached) {
+		t.Errorf("Expected ErrDailyLimitReached, got %v", err)
+	}
+	if err := For(Red).CheckDailyQuota(10000); err != nil {
+		t.Errorf("Expected red to have no daily limit, got %v", err)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(For(Red))
+	if err != nil {
+		t.Fatalf("Error marshalling entitlements: %v", err)
+	}
+	fields := map[string]any{}
+	json.Unmarshal(data, &fields)
+	if fields["tier"] != "red" || fields["edit_window_seconds"] != float64(1800) || fields["ad_free"] != true {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+}
@@ -0,0 +1,130 @@
+// Package logging sets up structured logging. Records are tagged with the
+// request they were logged during, and anything that looks like a secret is
+// redacted before it is written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values are never logged.
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie", "api_key", "apikey", "recovery_code"}
+
+// secretPatterns match secrets embedded in otherwise loggable strings, such
+// as an error message quoting a header or a URL with a token in its query.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`chirpy_pat_[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`(?i)([?&](?:token|code|secret|password|api_key)=)[^&\s"]+`),
+}
+
+type Options struct {
+	Level slog.Leveler
+	// Format is "json" or "text".
+	Format string
+}
+
+// ParseLevel reads debug, info, warn or error.
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+	return level, nil
+}
+
+func New(writer io.Writer, options Options) (*slog.Logger, error) {
+	handlerOptions := &slog.HandlerOptions{Level: options.Level, ReplaceAttr: redactAttr}
+
+	var handler slog.Handler
+	switch options.Format {
+	case "", "json":
+		handler = slog.NewJSONHandler(writer, handlerOptions)
+	case "text":
+		handler = slog.NewTextHandler(writer, handlerOptions)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", options.Format)
+	}
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	// IDs refer to secrets without revealing them.
+	if strings.HasSuffix(key, "_id") {
+		return false
+	}
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact masks secrets found in a string.
+func Redact(value string) string {
+	for _, pattern := range secretPatterns {
+		value = pattern.ReplaceAllStringFunc(value, func(match string) string {
+			// Keep the query parameter's name, so the line still makes
+			// sense.
+			if i := strings.IndexByte(match, '='); i >= 0 && (match[0] == '?' || match[0] == '&') {
+				return match[:i+1] + redacted
+			}
+			return redacted
+		})
+	}
+	return value
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key != slog.MessageKey && isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, Redact(err.Error()))
+		}
+	}
+	return attr
+}
+
+// contextHandler adds the request ID and user ID of the request a record
+// was logged during, and the trace and span it belongs to.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info, ok := requestFromContext(ctx); ok {
+		record.AddAttrs(slog.String("request_id", info.id))
+		if userID := info.userID.Load(); userID != nil {
+			record.AddAttrs(slog.String("user_id", *userID))
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
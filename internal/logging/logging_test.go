@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	lines := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]any{}
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatalf("Error decoding log line %q: %v", line, err)
+		}
+		lines = append(lines, record)
+	}
+	return lines
+}
+
+func TestRedaction(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, Options{Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatalf("Error creating logger: %v", err)
+	}
+
+	logger.Info("Calling https://chirpy.test/api/exports/1?token=abc123&x=1",
+		"password", "hunter2",
+		"refresh_token", "r3fr3sh",
+		"token_id", "tok_1",
+		"header", "Bearer eyJhbGciOiJFZERTQSJ9.eyJzdWIiOiIxIn0.c2ln",
+		"err", errors.New("bad token chirpy_pat_0123456789abcdef"),
+	)
+
+	record := decodeLines(t, buf)[0]
+	expected := map[string]any{
+		"msg":           "Calling https://chirpy.test/api/exports/1?token=[REDACTED]&x=1",
+		"password":      "[REDACTED]",
+		"refresh_token": "[REDACTED]",
+		"token_id":      "tok_1",
+		"header":        "[REDACTED]",
+		"err":           "bad token [REDACTED]",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Expected %s to be %q, got %q", key, value, record[key])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warn")
+	if err != nil || level != slog.LevelWarn {
+		t.Errorf("Expected warn, got %v, %v", level, err)
+	}
+	_, err = ParseLevel("loud")
+	if err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := New(buf, Options{Level: slog.LevelInfo})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), "user-1")
+		logger.InfoContext(r.Context(), "handling")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	handler := Middleware(logger, mux)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/chirps/42", nil)
+	request.Header.Set(RequestIDHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("Expected the request ID to be echoed, got %q", recorder.Header().Get(RequestIDHeader))
+	}
+	lines := decodeLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+	if lines[0]["request_id"] != "abc-123" || lines[0]["user_id"] != "user-1" {
+		t.Errorf("Expected handler logs to carry the request and user IDs, got %v", lines[0])
+	}
+	access := lines[1]
+	for key, value := range map[string]any{
+		"msg": "request", "method": "POST", "route": "/api/chirps/{chirpID}",
+		"status": float64(201), "bytes": float64(5), "request_id": "abc-123", "user_id": "user-1",
+	} {
+		if access[key] != value {
+			t.Errorf("Expected access log %s to be %v, got %v", key, value, access[key])
+		}
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	generated := recorder.Header().Get(RequestIDHeader)
+	if len(generated) != 32 {
+		t.Errorf("Expected a malformed request ID to be replaced, got %q", generated)
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := New(buf, Options{Level: slog.LevelInfo})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	logger.InfoContext(ctx, "traced")
+	logger.Info("untraced")
+
+	lines := decodeLines(t, buf)
+	if lines[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || lines[0]["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("Expected the trace and span IDs to be logged, got %v", lines[0])
+	}
+	if _, found := lines[1]["trace_id"]; found {
+		t.Errorf("Expected no trace ID outside a span, got %v", lines[1])
+	}
+}
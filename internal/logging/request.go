@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/response"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Propagated request IDs are limited to characters that are safe to log
+// and echo back.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestInfo struct {
+	id     string
+	userID atomic.Pointer[string]
+}
+
+type requestContextKey struct{}
+
+func requestFromContext(ctx context.Context) (*requestInfo, bool) {
+	info, ok := ctx.Value(requestContextKey{}).(*requestInfo)
+	return info, ok
+}
+
+// RequestID returns the ID of the request ctx belongs to.
+func RequestID(ctx context.Context) string {
+	info, ok := requestFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return info.id
+}
+
+// SetUserID records who made the request, once they have authenticated, for
+// the access log and any later log lines.
+func SetUserID(ctx context.Context, userID string) {
+	if info, ok := requestFromContext(ctx); ok {
+		info.userID.Store(&userID)
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Middleware gives each request an ID, taking the caller's X-Request-ID if
+// it is well formed, and writes an access log line once it has been served.
+// Wrap the ServeMux directly, or with handlers that pass the request on
+// unchanged, so the route pattern the mux matched can be logged.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		info := &requestInfo{id: r.Header.Get(RequestIDHeader)}
+		if !requestIDPattern.MatchString(info.id) {
+			info.id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestContextKey{}, info))
+
+		recorder := response.Record(w)
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.Status()
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		} else if _, path, found := strings.Cut(route, " "); found {
+			route = path
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Int64("bytes", recorder.Bytes()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
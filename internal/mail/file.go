@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each message to its own .eml file in Dir, which is
+// handy for local development.
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	now := time.Now()
+	data, err := Format(message, now)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	// Naming the file after the message ID makes a retried send overwrite
+	// the earlier copy instead of duplicating it.
+	name := message.ID
+	if name == "" {
+		name = fmt.Sprintf("%d", now.UnixNano())
+	}
+	return os.WriteFile(filepath.Join(m.Dir, name+".eml"), data, 0o600)
+}
+
+// LogMailer writes messages to a logger instead of delivering them.
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	logger := m.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
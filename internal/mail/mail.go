@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+type Message struct {
+	ID      string
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a single message. Implementations should be safe to call
+// again for the same message, since the outbox retries on any error.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NormalizeAddress trims and lower-cases a bare email address and checks
+// that it is well formed. Display names and angle brackets are rejected.
+func NormalizeAddress(address string) (string, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if len(address) > 254 {
+		return "", ErrInvalidAddress
+	}
+
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Name != "" || parsed.Address != address {
+		return "", ErrInvalidAddress
+	}
+	// Require a dotted domain; nobody signs up from a bare hostname.
+	if !strings.Contains(domain(address), ".") {
+		return "", ErrInvalidAddress
+	}
+	return address, nil
+}
+
+// Format renders a plain text message in RFC 5322 form.
+func Format(message Message, date time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	if message.ID != "" {
+		fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", message.ID, domain(from.Address))
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.Write(bytes.ReplaceAll([]byte(message.Body), []byte("\n"), []byte("\r\n")))
+	return buf.Bytes(), nil
+}
+
+func domain(address string) string {
+	for i := len(address) - 1; i >= 0; i-- {
+		if address[i] == '@' {
+			return address[i+1:]
+		}
+	}
+	return "localhost"
+}
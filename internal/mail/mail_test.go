@@ -0,0 +1,164 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	ID:      "0b3c6f2e",
+	From:    "Chirpy <no-reply@chirpy.test>",
+	To:      "walt@breakingbad.com",
+	Subject: "Réinitialiser votre mot de passe",
+	Body:    "Hello,\nfollow the link below.",
+}
+
+func TestFormat(t *testing.T) {
+	data, err := Format(testMessage, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Error formatting message: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error parsing formatted message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("Error decoding subject: %v", err)
+	}
+	if subject != testMessage.Subject {
+		t.Errorf("Expected subject %q, got %q", testMessage.Subject, subject)
+	}
+	if got := parsed.Header.Get("To"); got != "<walt@breakingbad.com>" {
+		t.Errorf("Expected To <walt@breakingbad.com>, got %q", got)
+	}
+	if got := parsed.Header.Get("Message-ID"); got != "<0b3c6f2e@chirpy.test>" {
+		t.Errorf("Expected Message-ID <0b3c6f2e@chirpy.test>, got %q", got)
+	}
+
+	body, _ := io.ReadAll(parsed.Body)
+	if string(body) != "Hello,\r\nfollow the link below." {
+		t.Errorf("Expected CRLF body, got %q", body)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	message := testMessage
+	message.To = "walt@breakingbad.com\r\nBcc: everyone@example.com"
+	_, err := Format(message, time.Now())
+	if err == nil {
+		t.Error("Expected error for address containing a newline, got nil")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &FileMailer{Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		err := mailer.Send(context.Background(), testMessage)
+		if err != nil {
+			t.Fatalf("Error sending message: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading mail directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected retried send to overwrite, got %d files", len(entries))
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if !strings.Contains(string(data), "follow the link below.") {
+		t.Errorf("Expected file to contain the body, got %q", data)
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer := &LogMailer{Logger: log.New(&buf, "", 0)}
+
+	err := mailer.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	if !strings.Contains(buf.String(), testMessage.To) {
+		t.Errorf("Expected log to mention %s, got %q", testMessage.To, buf.String())
+	}
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	// A relay that accepts the connection and never says anything.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	mailer := &SMTPMailer{Addr: listener.Addr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- mailer.Send(ctx, testMessage) }()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected send to give up when the context expired")
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{input: "walt@breakingbad.com", expected: "walt@breakingbad.com", valid: true},
+		{input: "  Walt@BreakingBad.COM\n", expected: "walt@breakingbad.com", valid: true},
+		{input: "walt+chirpy@breakingbad.com", expected: "walt+chirpy@breakingbad.com", valid: true},
+		{input: "", valid: false},
+		{input: "walt", valid: false},
+		{input: "walt@localhost", valid: false},
+		{input: "walt@@breakingbad.com", valid: false},
+		{input: "Walt <walt@breakingbad.com>", valid: false},
+		{input: "walt@breakingbad.com, jesse@breakingbad.com", valid: false},
+	}
+
+	for _, test := range tests {
+		normalized, err := NormalizeAddress(test.input)
+		if test.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", test.input, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress for %q, got %v", test.input, err)
+		}
+		if normalized != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, normalized)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends through a relay. The connection is upgraded with STARTTLS
+// when the server offers it, and credentials are only sent over TLS.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+// Send delivers one message. net/smtp has no timeouts of its own, so the
+// whole exchange is bounded by ctx: its deadline applies to the connection,
+// and cancelling it closes the connection.
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	data, err := Format(message, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(message.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = m.send(conn, host, from.Address, to.Address, data)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (m *SMTPMailer) send(conn net.Conn, host string, from string, to string, data []byte) error {
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ok, _ := client.Extension("STARTTLS")
+	if ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		ok, _ = client.Extension("AUTH")
+		if !ok {
+			return errors.New("mail: server doesn't support AUTH")
+		}
+		// PlainAuth refuses to send credentials unless the connection is
+		// encrypted or to localhost.
+		err = client.Auth(smtp.PlainAuth("", m.Username, m.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,147 @@
+// Package metrics collects Chirpy's Prometheus metrics in a registry of its
+// own, so tests and the admin pages see exactly what /metrics exposes.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/response"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const namespace = "chirpy"
+
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	fileserverHits prometheus.Counter
+	hitsMu         sync.Mutex
+	hitsBaseline   float64
+
+	ChirpsCreated prometheus.Counter
+	// Logins is labelled by method, such as password or passkey, and
+	// result: success, failure or mfa_required.
+	Logins *prometheus.CounterVec
+	// WebhookEvents is labelled by provider and outcome.
+	WebhookEvents *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "http_requests_total",
+			Help: "HTTP requests served, by route pattern, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests, by route pattern, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		fileserverHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "fileserver_hits_total",
+			Help: "Requests for the web app's static files.",
+		}),
+		ChirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "chirps_created_total",
+			Help: "Chirps posted.",
+		}),
+		Logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "logins_total",
+			Help: "Login attempts, by method and result.",
+		}, []string{"method", "result"}),
+		WebhookEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "webhook_events_total",
+			Help: "Billing webhook deliveries, by provider and outcome.",
+		}, []string{"provider", "outcome"}),
+	}
+	m.Registry.MustRegister(
+		m.requests, m.duration, m.inFlight, m.fileserverHits, m.ChirpsCreated, m.Logins, m.WebhookEvents,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// RegisterDB exports the connection pool's statistics.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware records every request. It must wrap the ServeMux, which sets
+// the request's Pattern; labelling by pattern rather than path keeps IDs out
+// of the label values.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		recorder := response.Record(w)
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.Status()
+		labels := prometheus.Labels{"route": routeLabel(r.Pattern), "method": r.Method, "status": strconv.Itoa(status)}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+func routeLabel(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	// The method is a label of its own.
+	if _, path, found := strings.Cut(pattern, " "); found {
+		return path
+	}
+	return pattern
+}
+
+func (m *Metrics) CountFileserverHit() {
+	m.fileserverHits.Inc()
+}
+
+// FileserverHits returns the hits since the count was last reset.
+func (m *Metrics) FileserverHits() int {
+	m.hitsMu.Lock()
+	defer m.hitsMu.Unlock()
+	return int(counterValue(m.fileserverHits) - m.hitsBaseline)
+}
+
+// ResetFileserverHits restarts the count shown by FileserverHits. The
+// exported counter keeps counting, since Prometheus counters only go up.
+func (m *Metrics) ResetFileserverHits() {
+	m.hitsMu.Lock()
+	defer m.hitsMu.Unlock()
+	m.hitsBaseline = counterValue(m.fileserverHits)
+}
+
+func counterValue(counter prometheus.Counter) float64 {
+	metric := &dto.Metric{}
+	err := counter.Write(metric)
+	if err != nil {
+		return 0
+	}
+	return metric.GetCounter().GetValue()
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	return string(body)
+}
+
+func TestMiddlewareLabelsByPattern(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+
+	for _, path := range []string{"/api/chirps/1", "/api/chirps/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		`chirpy_http_requests_total{method="GET",route="/api/chirps/{chirpID}",status="404"} 2`,
+		`chirpy_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`chirpy_http_request_duration_seconds_count{method="GET",route="/api/chirps/{chirpID}",status="404"} 2`,
+		`chirpy_http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected scrape to contain %q", expected)
+		}
+	}
+}
+
+func TestFileserverHitsReset(t *testing.T) {
+	m := New()
+	m.CountFileserverHit()
+	m.CountFileserverHit()
+	if m.FileserverHits() != 2 {
+		t.Errorf("Expected 2 hits, got %d", m.FileserverHits())
+	}
+
+	m.ResetFileserverHits()
+	m.CountFileserverHit()
+	if m.FileserverHits() != 1 {
+		t.Errorf("Expected 1 hit after reset, got %d", m.FileserverHits())
+	}
+	if !strings.Contains(scrape(t, m), "chirpy_fileserver_hits_total 3") {
+		t.Errorf("Expected the exported counter to keep counting across resets")
+	}
+}
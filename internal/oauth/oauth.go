@@ -0,0 +1,140 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Error codes from RFC 6749 section 4.1.2.1 and 5.2.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+)
+
+// Error is an OAuth error response.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func NewError(code string, format string, args ...any) *Error {
+	return &Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+const (
+	clientIDPrefix     = "chirpy_client_"
+	clientSecretPrefix = "chirpy_cs_"
+)
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func MakeClientID() (string, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return "", err
+	}
+	return clientIDPrefix + id, nil
+}
+
+// MakeClientSecret returns a secret for a confidential client. Like personal
+// access tokens, only its hash is stored.
+func MakeClientSecret() (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	return clientSecretPrefix + secret, nil
+}
+
+// VerifyCodeChallenge checks a PKCE code_verifier against the S256
+// code_challenge sent with the authorization request (RFC 7636).
+func VerifyCodeChallenge(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// ParseScope splits a space-separated scope parameter and checks every scope
+// is in allowed. An empty scope means all of allowed.
+func ParseScope(scope string, allowed []string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return slices.Clone(allowed), nil
+	}
+	for _, s := range scopes {
+		if !slices.Contains(allowed, s) {
+			return nil, NewError(ErrorInvalidScope, "scope %q is not allowed", s)
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// ValidateRedirectURI checks a URI a client wants to register. OAuth 2.1
+// requires exact matching, so fragments and wildcards are out, and plain
+// http is only allowed for loopback addresses.
+func ValidateRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return fmt.Errorf("redirect URI %q must be absolute", uri)
+	}
+	if parsed.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not have a fragment", uri)
+	}
+	if parsed.Scheme == "http" {
+		host := parsed.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("redirect URI %q must use https", uri)
+		}
+	} else if parsed.Scheme != "https" {
+		return fmt.Errorf("redirect URI %q must use https", uri)
+	}
+	return nil
+}
+
+// RedirectWithParams appends query parameters to a registered redirect URI,
+// keeping any query it already has.
+func RedirectWithParams(redirectURI string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
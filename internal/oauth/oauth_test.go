@@ -0,0 +1,94 @@
+package oauth
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !VerifyCodeChallenge(verifier, challenge) {
+		t.Error("Expected RFC 7636 example verifier to match")
+	}
+	if VerifyCodeChallenge(verifier+"x", challenge) {
+		t.Error("Expected a different verifier not to match")
+	}
+	if VerifyCodeChallenge("short", "short") {
+		t.Error("Expected a verifier shorter than 43 characters to be rejected")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	allowed := []string{"chirps:read", "chirps:write"}
+
+	scopes, err := ParseScope("", allowed)
+	if err != nil || !slices.Equal(scopes, allowed) {
+		t.Errorf("Expected empty scope to grant %v, got %v, %v", allowed, scopes, err)
+	}
+
+	scopes, err = ParseScope("chirps:write  chirps:read chirps:write", allowed)
+	if err != nil || !slices.Equal(scopes, []string{"chirps:read", "chirps:write"}) {
+		t.Errorf("Expected sorted, de-duplicated scopes, got %v, %v", scopes, err)
+	}
+
+	_, err = ParseScope("chirps:read tokens:manage", allowed)
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != ErrorInvalidScope {
+		t.Errorf("Expected invalid_scope, got %v", err)
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{uri: "https://app.example.com/callback", valid: true},
+		{uri: "http://localhost:3000/callback", valid: true},
+		{uri: "http://127.0.0.1/cb", valid: true},
+		{uri: "http://app.example.com/callback", valid: false},
+		{uri: "https://app.example.com/callback#frag", valid: false},
+		{uri: "/callback", valid: false},
+		{uri: "javascript:alert(1)", valid: false},
+	}
+
+	for _, test := range tests {
+		err := ValidateRedirectURI(test.uri)
+		if test.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", test.uri, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected %q to be rejected, got nil", test.uri)
+		}
+	}
+}
+
+func TestRedirectWithParams(t *testing.T) {
+	redirect := RedirectWithParams("https://app.example.com/cb?tenant=1", url.Values{"code": {"abc"}, "state": {"x y"}})
+
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("Error parsing redirect: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("tenant") != "1" || query.Get("code") != "abc" || query.Get("state") != "x y" {
+		t.Errorf("Expected existing and new params, got %q", redirect)
+	}
+}
+
+func TestMakeClientCredentials(t *testing.T) {
+	id, err := MakeClientID()
+	if err != nil || !strings.HasPrefix(id, clientIDPrefix) {
+		t.Errorf("Expected client ID with prefix %s, got %q, %v", clientIDPrefix, id, err)
+	}
+	secret, err := MakeClientSecret()
+	if err != nil || !strings.HasPrefix(secret, clientSecretPrefix) {
+		t.Errorf("Expected client secret with prefix %s, got %q, %v", clientSecretPrefix, secret, err)
+	}
+}
@@ -0,0 +1,116 @@
+package passkey
+
+import (
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+const ceremonyTimeout = 5 * time.Minute
+
+var (
+	ErrUnknownCredential = errors.New("unknown passkey")
+	// ErrClonedAuthenticator means the signature counter went backwards, so
+	// more than one copy of the credential's private key may exist.
+	ErrClonedAuthenticator = errors.New("passkey signature counter did not increase")
+)
+
+type Config struct {
+	RPID          string
+	RPDisplayName string
+	RPOrigins     []string
+}
+
+// User is a Chirpy user as seen by the WebAuthn ceremonies. The user handle
+// is the user's UUID.
+type User struct {
+	ID          uuid.UUID
+	Email       string
+	Credentials []webauthn.Credential
+}
+
+func (u *User) WebAuthnID() []byte {
+	return u.ID[:]
+}
+
+func (u *User) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnDisplayName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// UserIDFromHandle recovers the user ID from a user handle returned by an
+// authenticator during a discoverable login.
+func UserIDFromHandle(userHandle []byte) (uuid.UUID, error) {
+	return uuid.FromBytes(userHandle)
+}
+
+type Service struct {
+	webauthn *webauthn.WebAuthn
+}
+
+func New(config Config) (*Service, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigins:     config.RPOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Service{webauthn: w}, nil
+}
+
+// BeginRegistration starts adding a passkey to the user's account. The
+// returned session must be kept server-side until FinishRegistration.
+func (s *Service) BeginRegistration(user *User) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	return s.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(user.Credentials).CredentialDescriptors()),
+	)
+}
+
+func (s *Service) FinishRegistration(user *User, session webauthn.SessionData, request *http.Request) (*webauthn.Credential, error) {
+	return s.webauthn.FinishRegistration(user, session, request)
+}
+
+// BeginLogin starts a discoverable login, where the authenticator tells us
+// which user it holds a passkey for.
+func (s *Service) BeginLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return s.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishLogin verifies an assertion. lookup loads the user owning the
+// credential, including their registered credentials. The returned credential
+// carries the updated signature counter, which the caller must store.
+func (s *Service) FinishLogin(lookup func(userID uuid.UUID) (*User, error), session webauthn.SessionData, request *http.Request) (*User, *webauthn.Credential, error) {
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := UserIDFromHandle(userHandle)
+		if err != nil {
+			return nil, ErrUnknownCredential
+		}
+		return lookup(userID)
+	}
+
+	user, credential, err := s.webauthn.FinishPasskeyLogin(handler, session, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, ErrClonedAuthenticator
+	}
+	return user.(*User), credential, nil
+}
@@ -0,0 +1,250 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testRPID   = "chirpy.test"
+	testOrigin = "https://chirpy.test"
+)
+
+// softAuthenticator is a software passkey that produces the same responses
+// a browser would hand back from navigator.credentials.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating authenticator key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID, origin: testOrigin}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	clientData, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": b64(challenge),
+		"origin":    a.origin,
+	})
+	return clientData
+}
+
+func (a *softAuthenticator) authenticatorData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	var data bytes.Buffer
+	data.Write(rpIDHash[:])
+	data.WriteByte(byte(flags))
+	_ = binary.Write(&data, binary.BigEndian, a.signCount)
+	data.Write(attested)
+	return data.Bytes()
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("Error encoding public key: %v", err)
+	}
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	_ = binary.Write(&attested, binary.BigEndian, uint16(len(a.credentialID)))
+	attested.Write(a.credentialID)
+	attested.Write(publicKey)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(flags, attested.Bytes()),
+	})
+	if err != nil {
+		t.Fatalf("Error encoding attestation object: %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64(attestationObject),
+		},
+	})
+	return body
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authenticatorData := a.authenticatorData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authenticatorData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("Error signing assertion: %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authenticatorData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return body
+}
+
+func newTestService(t *testing.T) *Service {
+	service, err := New(Config{RPID: testRPID, RPDisplayName: "Chirpy", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatalf("Error creating service: %v", err)
+	}
+	return service
+}
+
+func postJSON(body []byte) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	return request
+}
+
+// register runs a full registration ceremony and stores the credential on the user.
+func register(t *testing.T, service *Service, user *User, authenticator *softAuthenticator) {
+	creation, session, err := service.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("Error beginning registration: %v", err)
+	}
+
+	credential, err := service.FinishRegistration(user, *session, postJSON(authenticator.create(t, creation)))
+	if err != nil {
+		t.Fatalf("Error finishing registration: %v", err)
+	}
+	user.Credentials = append(user.Credentials, *credential)
+}
+
+func login(t *testing.T, service *Service, user *User, authenticator *softAuthenticator) (*User, *webauthn.Credential, error) {
+	assertion, session, err := service.BeginLogin()
+	if err != nil {
+		t.Fatalf("Error beginning login: %v", err)
+	}
+
+	lookup := func(userID uuid.UUID) (*User, error) {
+		if userID != user.ID {
+			return nil, ErrUnknownCredential
+		}
+		return user, nil
+	}
+	return service.FinishLogin(lookup, *session, postJSON(authenticator.get(t, assertion)))
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	service := newTestService(t)
+	user := &User{ID: uuid.New(), Email: "walt@breakingbad.com"}
+	authenticator := newSoftAuthenticator(t)
+
+	register(t, service, user, authenticator)
+	if !bytes.Equal(user.Credentials[0].ID, authenticator.credentialID) {
+		t.Fatalf("Expected credential ID %x, got %x", authenticator.credentialID, user.Credentials[0].ID)
+	}
+
+	loggedIn, credential, err := login(t, service, user, authenticator)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Errorf("Expected user %v, got %v", user.ID, loggedIn.ID)
+	}
+	if credential.Authenticator.SignCount != 1 {
+		t.Errorf("Expected sign count 1, got %d", credential.Authenticator.SignCount)
+	}
+}
+
+func TestLoginDetectsClonedAuthenticator(t *testing.T) {
+	service := newTestService(t)
+	user := &User{ID: uuid.New(), Email: "walt@breakingbad.com"}
+	authenticator := newSoftAuthenticator(t)
+	register(t, service, user, authenticator)
+
+	_, credential, err := login(t, service, user, authenticator)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	user.Credentials[0] = *credential
+
+	// A clone replays an older counter value
+	authenticator.signCount = 0
+	_, _, err = login(t, service, user, authenticator)
+	if !errors.Is(err, ErrClonedAuthenticator) {
+		t.Errorf("Expected ErrClonedAuthenticator, got %v", err)
+	}
+}
+
+func TestLoginRejectsWrongOrigin(t *testing.T) {
+	service := newTestService(t)
+	user := &User{ID: uuid.New(), Email: "walt@breakingbad.com"}
+	authenticator := newSoftAuthenticator(t)
+	register(t, service, user, authenticator)
+
+	authenticator.origin = "https://phishing.test"
+	_, _, err := login(t, service, user, authenticator)
+	if err == nil {
+		t.Error("Expected error for assertion from another origin, got nil")
+	}
+}
+
+func TestLoginRejectsUnregisteredCredential(t *testing.T) {
+	service := newTestService(t)
+	user := &User{ID: uuid.New(), Email: "walt@breakingbad.com"}
+	register(t, service, user, newSoftAuthenticator(t))
+
+	stranger := newSoftAuthenticator(t)
+	stranger.userHandle = user.WebAuthnID()
+	_, _, err := login(t, service, user, stranger)
+	if err == nil {
+		t.Error("Expected error for unregistered credential, got nil")
+	}
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const prefixLength = 5
+
+// BreachedList is a set of known-breached passwords, held as SHA-1 hashes
+// bucketed by their first five hex digits in the style of the Have I Been
+// Pwned range API. Only hashes are kept in memory, and a lookup only ever
+// compares suffixes within one bucket.
+type BreachedList struct {
+	buckets map[string][]string
+}
+
+// LoadBreachedList reads a breached-password file. See ReadBreachedList for
+// the format.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadBreachedList(file)
+}
+
+// ReadBreachedList reads one SHA-1 hash per line, in hex and optionally
+// followed by ":count" as in the Have I Been Pwned downloads. Blank lines
+// and lines starting with # are ignored.
+func ReadBreachedList(reader io.Reader) (*BreachedList, error) {
+	list := &BreachedList{buckets: map[string][]string{}}
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		_, err := hex.DecodeString(hash)
+		if err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: expected a SHA-1 hash in hex", lineNumber)
+		}
+		prefix := hash[:prefixLength]
+		list.buckets[prefix] = append(list.buckets[prefix], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for prefix, suffixes := range list.buckets {
+		slices.Sort(suffixes)
+		list.buckets[prefix] = slices.Compact(suffixes)
+	}
+	return list, nil
+}
+
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, found := slices.BinarySearch(l.buckets[hash[:prefixLength]], hash[prefixLength:])
+	return found
+}
+
+// Len returns the number of distinct hashes in the list.
+func (l *BreachedList) Len() int {
+	total := 0
+	for _, suffixes := range l.buckets {
+		total += len(suffixes)
+	}
+	return total
+}
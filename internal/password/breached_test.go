@@ -0,0 +1,48 @@
+package password
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// SHA-1 of "correct horse battery staple", lowercased and with a count, and
+// of "P@ssw0rd" twice.
+const breachedFile = `# test list
+abf7aad6438836dbe526aa231abde2d0eef74d42:42
+
+21BD12DC183F740EE76F27B78EB39C8AD972A757
+21BD12DC183F740EE76F27B78EB39C8AD972A757:3
+`
+
+func TestBreachedList(t *testing.T) {
+	list, err := ReadBreachedList(strings.NewReader(breachedFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Len() != 2 {
+		t.Errorf("Expected 2 distinct hashes, got %d", list.Len())
+	}
+
+	for _, password := range []string{"correct horse battery staple", "P@ssw0rd"} {
+		if !list.Contains(password) {
+			t.Errorf("Expected %q to be breached", password)
+		}
+	}
+	if list.Contains("p@ssw0rd") {
+		t.Error("Expected lookups to be case-sensitive")
+	}
+
+	policy := Policy{Breached: list}
+	got := codes(policy.Check("correct horse battery staple"))
+	if !slices.Equal(got, []string{CodeBreached}) {
+		t.Errorf("Expected breached, got %v", got)
+	}
+}
+
+func TestReadBreachedListInvalid(t *testing.T) {
+	_, err := ReadBreachedList(strings.NewReader("not a hash\n"))
+	if err == nil {
+		t.Error("Expected error for malformed line, got nil")
+	}
+}
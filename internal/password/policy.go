@@ -0,0 +1,93 @@
+// Package password decides whether a new password is acceptable.
+package password
+
+import (
+	"fmt"
+	"github.com/ccojocar/zxcvbn-go"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation codes.
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeTooWeak       = "too_weak"
+	CodeContainsEmail = "contains_email"
+	CodeBreached      = "breached"
+)
+
+// Violation is one reason a password was rejected, suitable for returning to
+// the client as a field error.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Policy is the set of rules a new password must meet.
+type Policy struct {
+	// MinLength and MaxLength count characters, not bytes.
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest acceptable zxcvbn score, from 0 (guessable in
+	// a handful of tries) to 4 (very unguessable).
+	MinScore int
+	// Breached is consulted if set.
+	Breached *BreachedList
+}
+
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: 256, MinScore: 3}
+
+// Check returns every rule the password breaks. userInputs are strings
+// such as the user's email address, which the password must not contain
+// and which count against its strength.
+func (p Policy) Check(password string, userInputs ...string) []Violation {
+	violations := []Violation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{Code: CodeTooShort, Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		// Scoring is expensive on long input, so stop here.
+		violations = append(violations, Violation{Code: CodeTooLong, Message: fmt.Sprintf("Password must be at most %d characters long", p.MaxLength)})
+		return violations
+	}
+	if length == 0 {
+		return violations
+	}
+
+	if containsUserInput(password, userInputs) {
+		violations = append(violations, Violation{Code: CodeContainsEmail, Message: "Password must not contain your email address"})
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, Violation{Code: CodeBreached, Message: "Password has appeared in a data breach; choose another"})
+	} else if zxcvbn.PasswordStrength(password, userInputs).Score < p.MinScore {
+		violations = append(violations, Violation{Code: CodeTooWeak, Message: "Password is too easy to guess; try a longer phrase of uncommon words"})
+	}
+
+	return violations
+}
+
+// containsUserInput reports whether password contains any of the inputs, or
+// the local part of an email address among them, ignoring case. Very short
+// local parts are skipped so that "jo@example.com" doesn't rule out every
+// password containing "jo".
+func containsUserInput(password string, userInputs []string) bool {
+	password = strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if input == "" {
+			continue
+		}
+		if strings.Contains(password, input) {
+			return true
+		}
+		local, _, found := strings.Cut(input, "@")
+		if found && len(local) >= 4 && strings.Contains(password, local) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"slices"
+	"testing"
+)
+
+func codes(violations []Violation) []string {
+	result := []string{}
+	for _, violation := range violations {
+		result = append(result, violation.Code)
+	}
+	return result
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := DefaultPolicy
+
+	tests := []struct {
+		password string
+		expected []string
+	}{
+		{"", []string{CodeTooShort}},
+		{"abc", []string{CodeTooShort, CodeTooWeak}},
+		{"password", []string{CodeTooWeak}},
+		{"walter.white1", []string{CodeContainsEmail, CodeTooWeak}},
+		{"correct horse battery staple", []string{}},
+		{"Jd8#kq!vZ2@pLm", []string{}},
+	}
+	for _, test := range tests {
+		got := codes(policy.Check(test.password, "walter.white@example.com"))
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("Expected %v for %q, got %v", test.expected, test.password, got)
+		}
+	}
+}
+
+func TestPolicyCheckLength(t *testing.T) {
+	policy := Policy{MinLength: 4, MaxLength: 10}
+
+	got := codes(policy.Check("ééé"))
+	if !slices.Equal(got, []string{CodeTooShort}) {
+		t.Errorf("Expected length in characters to be too short, got %v", got)
+	}
+	got = codes(policy.Check("ééééé"))
+	if len(got) != 0 {
+		t.Errorf("Expected no violations, got %v", got)
+	}
+	got = codes(policy.Check("abcdefghijk"))
+	if !slices.Equal(got, []string{CodeTooLong}) {
+		t.Errorf("Expected too_long, got %v", got)
+	}
+}
+
+func TestPolicyCheckShortLocalPart(t *testing.T) {
+	policy := Policy{}
+
+	got := codes(policy.Check("jolly good fellow", "jo@example.com"))
+	if len(got) != 0 {
+		t.Errorf("Expected a short local part to be ignored, got %v", got)
+	}
+	got = codes(policy.Check("my JO@example.com password", "jo@example.com"))
+	if !slices.Equal(got, []string{CodeContainsEmail}) {
+		t.Errorf("Expected contains_email, got %v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatalf("Error parsing trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"direct", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"untrusted peer ignores header", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000", "198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.2:4000", "198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"spoofed left entries", "10.0.0.2:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"garbled chain", "10.0.0.2:4000", "198.51.100.1, nonsense", "10.0.0.2"},
+		{"all trusted", "10.0.0.2:4000", "10.0.0.3", "10.0.0.3"},
+		{"ipv6", "[2001:db8::1]:4000", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			ip := ClientIP(request, trusted)
+			if ip.String() != tt.expectedIP {
+				t.Errorf("Expected %s, got %s", tt.expectedIP, ip)
+			}
+		})
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	if err == nil {
+		t.Errorf("Expected an invalid prefix to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+// Package ratelimit implements token-bucket rate limiting. Each key has a
+// bucket holding up to a policy's limit of tokens, which refills evenly
+// over the policy's period; a request takes one token, and is refused when
+// the bucket is empty.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	Name string
+	// Limit requests are allowed each Period, in bursts of up to Limit.
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy reads a policy written as "<limit>/<period>", such as
+// "10/1m".
+func ParsePolicy(name string, value string) (Policy, error) {
+	limit, period, found := strings.Cut(value, "/")
+	if !found {
+		return Policy{}, fmt.Errorf("rate limit %q must be written as <limit>/<period>", value)
+	}
+	parsedLimit, err := strconv.Atoi(limit)
+	if err != nil || parsedLimit < 1 {
+		return Policy{}, fmt.Errorf("rate limit %q must allow at least one request", value)
+	}
+	parsedPeriod, err := time.ParseDuration(period)
+	if err != nil || parsedPeriod <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q must have a positive period", value)
+	}
+	return Policy{Name: name, Limit: parsedLimit, Period: parsedPeriod}, nil
+}
+
+// Rate is how many tokens are added back each second.
+func (p Policy) Rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result turns the tokens left in a bucket after a request into a Result.
+func (p Policy) Result(tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: max(0, int(math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(p.Limit) - tokens) / p.Rate()),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / p.Rate())
+	}
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(max(0, seconds) * float64(time.Second))
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed.
+	RetryAfter time.Duration
+}
+
+// SetHeaders sets the RateLimit headers from the IETF httpapi draft, and
+// Retry-After if the request was refused. Times are rounded up to whole
+// seconds so clients never retry too early.
+func (r Result) SetHeaders(header http.Header, policy Policy) {
+	header.Set("RateLimit-Limit", strconv.Itoa(r.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(r.Reset), 10))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Period)))
+	if !r.Allowed {
+		header.Set("Retry-After", strconv.FormatInt(max(1, ceilSeconds(r.RetryAfter)), 10))
+	}
+}
+
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
+
+// Store holds buckets. Take must refill and take from a bucket atomically,
+// so concurrent requests for one key can't overspend it.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
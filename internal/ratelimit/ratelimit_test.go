@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("login", "10/1m")
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+	if policy.Limit != 10 || policy.Period != time.Minute {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	for _, value := range []string{"10", "0/1m", "ten/1m", "10/soon", "10/-1m"} {
+		_, err := ParsePolicy("login", value)
+		if err == nil {
+			t.Errorf("Expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.Now = func() time.Time { return now }
+	policy := Policy{Name: "test", Limit: 3, Period: 3 * time.Second}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take(ctx, "a", policy)
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 2-i, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "a", policy)
+	if result.Allowed {
+		t.Fatalf("Expected the fourth request to be refused")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("Expected to retry after 1s, got %v", result.RetryAfter)
+	}
+
+	result, _ = store.Take(ctx, "b", policy)
+	if !result.Allowed {
+		t.Errorf("Expected another key to have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	result, _ = store.Take(ctx, "a", policy)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected one token to have refilled, got %+v", result)
+	}
+
+	now = now.Add(time.Hour)
+	result, _ = store.Take(ctx, "a", policy)
+	if result.Remaining != 2 {
+		t.Errorf("Expected the bucket to refill no further than the limit, got %+v", result)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Take(ctx, "short", Policy{Limit: 1, Period: time.Second})
+	store.Take(ctx, "long", Policy{Limit: 1, Period: time.Hour})
+
+	now = now.Add(time.Minute)
+	store.Sweep(ctx)
+	if store.Len() != 1 {
+		t.Errorf("Expected only the refilled bucket to be swept, got %d left", store.Len())
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	policy := Policy{Limit: 10, Period: time.Minute}
+	header := http.Header{}
+	policy.Result(0.5, false).SetHeaders(header, policy)
+
+	expected := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "57",
+		"RateLimit-Policy":    "10;w=60",
+		"Retry-After":         "3",
+	}
+	for name, value := range expected {
+		if header.Get(name) != value {
+			t.Errorf("Expected %s: %s, got %q", name, value, header.Get(name))
+		}
+	}
+
+	header = http.Header{}
+	policy.Result(9, true).SetHeaders(header, policy)
+	if header.Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After on an allowed request")
+	}
+}
@@ -0,0 +1,57 @@
+// Package response records what handlers write, for middleware that logs,
+// measures or traces requests.
+package response
+
+import "net/http"
+
+// Recorder remembers the status and size of the response written through
+// it.
+type Recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Record wraps w in a Recorder. If w already is one, say because another
+// middleware wrapped it first, it is returned as is, so stacked middleware
+// share one recorder rather than each adding a layer.
+func Record(w http.ResponseWriter) *Recorder {
+	if recorder, ok := w.(*Recorder); ok {
+		return recorder
+	}
+	return &Recorder{ResponseWriter: w}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the status sent, which is 200 if the handler wrote
+// nothing.
+func (r *Recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Bytes returns the size of the body written so far.
+func (r *Recorder) Bytes() int64 {
+	return r.bytes
+}
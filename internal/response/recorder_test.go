@@ -0,0 +1,36 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := Record(httptest.NewRecorder())
+	if status := recorder.Status(); status != http.StatusOK {
+		t.Errorf("Expected 200 before anything is written, got %d", status)
+	}
+
+	recorder.WriteHeader(http.StatusNotFound)
+	recorder.WriteHeader(http.StatusInternalServerError)
+	recorder.Write([]byte("not found"))
+	if status := recorder.Status(); status != http.StatusNotFound {
+		t.Errorf("Expected the first status, 404, got %d", status)
+	}
+	if bytes := recorder.Bytes(); bytes != 9 {
+		t.Errorf("Expected 9 bytes, got %d", bytes)
+	}
+}
+
+func TestRecordReusesRecorder(t *testing.T) {
+	outer := Record(httptest.NewRecorder())
+	inner := Record(outer)
+	if inner != outer {
+		t.Error("Expected wrapping a Recorder to return it unchanged")
+	}
+	inner.WriteHeader(http.StatusTeapot)
+	if status := outer.Status(); status != http.StatusTeapot {
+		t.Errorf("Expected outer recorder to see 418, got %d", status)
+	}
+}
@@ -0,0 +1,155 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+)
+
+var (
+	ErrMissingIDToken = errors.New("token response did not include an id_token")
+	ErrNonceMismatch  = errors.New("id_token nonce does not match")
+)
+
+type ProviderConfig struct {
+	// Name identifies the provider in URLs and stored identities.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// TrustMFA skips Chirpy's own second factor for users who sign in
+	// through this provider, leaving it to the provider to enforce one.
+	TrustMFA bool
+	// Client makes the requests to the provider. It defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// Identity is what a provider told us about the user who signed in.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider is an OpenID Connect identity provider we act as a relying party
+// for.
+type Provider struct {
+	Name     string
+	TrustMFA bool
+	client   *http.Client
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewProvider fetches the issuer's discovery document, so it needs the
+// provider to be reachable. The provider's signing keys are fetched later
+// using ctx, so it should outlive the provider.
+func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error) {
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", config.Name, err)
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	return &Provider{
+		Name:     config.Name,
+		TrustMFA: config.TrustMFA,
+		client:   client,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// LoginState is the per-login secret material that has to be kept between
+// redirecting to the provider and handling its callback.
+type LoginState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func NewLoginState() (*LoginState, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &LoginState{State: state, Nonce: nonce, CodeVerifier: oauth2.GenerateVerifier()}, nil
+}
+
+// AuthCodeURL is where to send the user to sign in, using PKCE with S256.
+func (p *Provider) AuthCodeURL(login *LoginState) string {
+	return p.oauth2.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.CodeVerifier))
+}
+
+// Exchange redeems an authorization code and verifies the returned ID token,
+// including its nonce.
+func (p *Provider) Exchange(ctx context.Context, code string, login *LoginState) (*Identity, error) {
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:      p.Name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
@@ -0,0 +1,270 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "s3cret"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that enforces PKCE. Tests stand in for the browser by calling authorize.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+	verified  bool
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating IdP key: %v", err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func writeJSON(writer http.ResponseWriter, status int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+func (idp *mockIdP) discovery(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, map[string]any{
+		"issuer":                                idp.server.URL,
+		"authorization_endpoint":                idp.server.URL + "/authorize",
+		"token_endpoint":                        idp.server.URL + "/token",
+		"jwks_uri":                              idp.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *mockIdP) jwks(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "mock",
+		"n": base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+// authorize plays the part of the user signing in at the IdP and returns the
+// code the IdP would redirect back with.
+func (idp *mockIdP) authorize(t *testing.T, authURL string, grant mockGrant) (code string, state string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Error parsing auth URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Expected code_challenge_method S256, got %q", query.Get("code_challenge_method"))
+	}
+	grant.challenge = query.Get("code_challenge")
+	grant.nonce = query.Get("nonce")
+
+	code, _ = randomString()
+	idp.mu.Lock()
+	idp.codes[code] = grant
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (idp *mockIdP) token(writer http.ResponseWriter, request *http.Request) {
+	clientID, clientSecret, ok := request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = request.PostFormValue("client_id"), request.PostFormValue("client_secret")
+	}
+	if clientID != testClientID || clientSecret != testClientSecret {
+		writeJSON(writer, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	grant, ok := idp.codes[request.PostFormValue("code")]
+	delete(idp.codes, request.PostFormValue("code"))
+	idp.mu.Unlock()
+	if !ok {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(request.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": idp.server.URL, "sub": grant.subject, "aud": testClientID,
+		"iat": now.Unix(), "exp": now.Add(time.Minute).Unix(),
+		"nonce": grant.nonce, "email": grant.email, "email_verified": grant.verified,
+	})
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string]any{
+		"access_token": "mock-access-token", "token_type": "Bearer", "expires_in": 60, "id_token": signed,
+	})
+}
+
+func newTestProvider(t *testing.T, idp *mockIdP) *Provider {
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Name:         "corp",
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "https://chirpy.test/api/auth/oidc/corp/callback",
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	return provider
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(t, idp)
+
+	login, err := NewLoginState()
+	if err != nil {
+		t.Fatalf("Error creating login state: %v", err)
+	}
+	code, state := idp.authorize(t, provider.AuthCodeURL(login), mockGrant{subject: "u-42", email: "walt@breakingbad.com", verified: true})
+	if state != login.State {
+		t.Errorf("Expected state %q, got %q", login.State, state)
+	}
+
+	identity, err := provider.Exchange(context.Background(), code, login)
+	if err != nil {
+		t.Fatalf("Error exchanging code: %v", err)
+	}
+	expected := Identity{Provider: "corp", Subject: "u-42", Email: "walt@breakingbad.com", EmailVerified: true}
+	if *identity != expected {
+		t.Errorf("Expected identity %+v, got %+v", expected, *identity)
+	}
+}
+
+type recordingTransport struct {
+	paths []string
+}
+
+func (r *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	r.paths = append(r.paths, request.URL.Path)
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestProviderClient(t *testing.T) {
+	idp := newMockIdP(t)
+	transport := &recordingTransport{}
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Name:         "corp",
+		Issuer:       idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "https://chirpy.test/api/auth/oidc/corp/callback",
+		Client:       &http.Client{Transport: transport},
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	login, _ := NewLoginState()
+	code, _ := idp.authorize(t, provider.AuthCodeURL(login), mockGrant{subject: "u-42"})
+	_, err = provider.Exchange(context.Background(), code, login)
+	if err != nil {
+		t.Fatalf("Error exchanging code: %v", err)
+	}
+
+	// Discovery, the token exchange and fetching the signing keys
+	if len(transport.paths) != 3 {
+		t.Errorf("Expected every request to the provider to use the client, got %v", transport.paths)
+	}
+}
+
+func TestExchangeRequiresCodeVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(t, idp)
+
+	login, _ := NewLoginState()
+	code, _ := idp.authorize(t, provider.AuthCodeURL(login), mockGrant{subject: "u-42"})
+
+	// An attacker who intercepted the code doesn't have the verifier
+	stolen, _ := NewLoginState()
+	stolen.Nonce = login.Nonce
+	_, err := provider.Exchange(context.Background(), code, stolen)
+	if err == nil {
+		t.Error("Expected error exchanging code with the wrong verifier, got nil")
+	}
+}
+
+func TestExchangeChecksNonce(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(t, idp)
+
+	login, _ := NewLoginState()
+	code, _ := idp.authorize(t, provider.AuthCodeURL(login), mockGrant{subject: "u-42"})
+
+	replayed := *login
+	replayed.Nonce = "something-else"
+	_, err := provider.Exchange(context.Background(), code, &replayed)
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestExchangeRejectsOtherAudience(t *testing.T) {
+	idp := newMockIdP(t)
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Name: "corp", Issuer: idp.server.URL, ClientID: "someone-else", ClientSecret: testClientSecret,
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	// The token endpoint only knows testClientID, so authenticate as that
+	// while verifying ID tokens for another client.
+	provider.oauth2.ClientID = testClientID
+
+	login, _ := NewLoginState()
+	code, _ := idp.authorize(t, provider.AuthCodeURL(login), mockGrant{subject: "u-42"})
+	_, err = provider.Exchange(context.Background(), code, login)
+	if err == nil {
+		t.Error("Expected error for an ID token issued to another client, got nil")
+	}
+}
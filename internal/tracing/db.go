@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"strings"
+)
+
+// DB wraps a database connection or transaction so that every query made
+// through it gets a span, named after the sqlc query it runs.
+type DB struct {
+	db     database.DBTX
+	tracer trace.Tracer
+}
+
+// WrapDB returns db with tracing, for passing to database.New.
+func WrapDB(db database.DBTX, provider trace.TracerProvider) *DB {
+	return &DB{db: db, tracer: provider.Tracer(instrumentationName)}
+}
+
+// QueryName returns the name sqlc gave a query, from the "-- name: GetUser
+// :one" comment it starts with, or "" if it has none.
+func QueryName(query string) string {
+	rest, found := strings.CutPrefix(strings.TrimSpace(query), "-- name: ")
+	if !found {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
+
+func (d *DB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := QueryName(query)
+	spanName := name
+	if spanName == "" {
+		spanName = "query"
+	}
+	attributes := []attribute.KeyValue{semconv.DBSystemNamePostgreSQL, semconv.DBQueryText(query)}
+	if name != "" {
+		attributes = append(attributes, semconv.DBOperationName(name))
+	}
+	return d.tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := d.start(ctx, query)
+	result, err := d.db.ExecContext(ctx, query, args...)
+	end(span, err)
+	return result, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := d.start(ctx, query)
+	stmt, err := d.db.PrepareContext(ctx, query)
+	end(span, err)
+	return stmt, err
+}
+
+// QueryContext's span covers running the query but not reading its rows,
+// since those are read after it returns.
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := d.start(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	end(span, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := d.start(ctx, query)
+	row := d.db.QueryRowContext(ctx, query, args...)
+	end(span, row.Err())
+	return row
+}
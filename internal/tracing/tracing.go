@@ -0,0 +1,88 @@
+// Package tracing sets up OpenTelemetry tracing: a span for each HTTP
+// request, each sqlc query and each outgoing HTTP call, with the W3C
+// traceparent header carried in and out.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"io"
+	"os"
+)
+
+const instrumentationName = "pjh.id.au/chirpy/v2/internal/tracing"
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// propagator reads and writes the W3C traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+type Options struct {
+	// Exporter is "none", "otlp" or "stdout".
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, such as
+	// http://localhost:4318. If empty, the exporter's own defaults and
+	// OTEL_EXPORTER_OTLP_* environment variables apply.
+	Endpoint    string
+	ServiceName string
+	// Writer receives spans from the stdout exporter. It defaults to
+	// standard output.
+	Writer io.Writer
+}
+
+// Setup creates a tracer provider that sends spans to the configured
+// exporter. The returned function flushes any spans still buffered and
+// stops the exporter.
+func Setup(ctx context.Context, options Options) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch options.Exporter {
+	case "", ExporterNone:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporterOptions := []otlptracehttp.Option{}
+		if options.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(options.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, exporterOptions...)
+	case ExporterStdout:
+		writer := options.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", options.Exporter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider := NewProvider(options.ServiceName, sdktrace.WithBatcher(exporter))
+	return provider, provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider for the named service. Tests pass
+// sdktrace.WithSyncer with an in-memory exporter, so spans can be checked
+// as soon as they end.
+func NewProvider(serviceName string, options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	if serviceName == "" {
+		serviceName = "chirpy"
+	}
+	options = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	}, options...)
+	return sdktrace.NewTracerProvider(options...)
+}
@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return NewProvider("chirpy-test", sdktrace.WithSyncer(exporter)), exporter
+}
+
+func attributeValue(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestQueryName(t *testing.T) {
+	cases := map[string]string{
+		"-- name: GetUser :one\nSELECT * FROM users WHERE id = $1": "GetUser",
+		"\n-- name: DeleteAllUsers :exec\nDELETE FROM users":       "DeleteAllUsers",
+		"SELECT 1": "",
+	}
+	for query, expected := range cases {
+		if name := QueryName(query); name != expected {
+			t.Errorf("Expected QueryName(%q) to be %q, got %q", query, expected, name)
+		}
+	}
+}
+
+type fakeDB struct {
+	err error
+}
+
+func (f fakeDB) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestDBSpans(t *testing.T) {
+	provider, exporter := newTestProvider()
+
+	WrapDB(fakeDB{}, provider).QueryContext(context.Background(), "-- name: GetChirps :many\nSELECT * FROM chirps")
+	WrapDB(fakeDB{err: errors.New("connection refused")}, provider).ExecContext(context.Background(), "-- name: DeleteAllUsers :exec\nDELETE FROM users")
+	WrapDB(fakeDB{err: sql.ErrNoRows}, provider).ExecContext(context.Background(), "SELECT 1")
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "GetChirps" || attributeValue(spans[0], "db.operation.name").AsString() != "GetChirps" {
+		t.Errorf("Expected a GetChirps span, got %q", spans[0].Name)
+	}
+	if attributeValue(spans[0], "db.system.name").AsString() != "postgresql" {
+		t.Errorf("Expected db.system.name to be postgresql")
+	}
+	if spans[0].SpanKind != trace.SpanKindClient || spans[0].Status.Code != codes.Unset {
+		t.Errorf("Expected an unset client span, got %v %v", spans[0].SpanKind, spans[0].Status.Code)
+	}
+	if spans[1].Name != "DeleteAllUsers" || spans[1].Status.Code != codes.Error {
+		t.Errorf("Expected DeleteAllUsers to fail, got %q %v", spans[1].Name, spans[1].Status.Code)
+	}
+	if spans[2].Name != "query" || spans[2].Status.Code != codes.Unset {
+		t.Errorf("Expected an unnamed query with no rows not to fail, got %q %v", spans[2].Name, spans[2].Status.Code)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	provider, exporter := newTestProvider()
+
+	mux := http.NewServeMux()
+	var handlerSpan trace.SpanContext
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := Middleware(provider, mux, mux)
+
+	request := httptest.NewRequest("GET", "/api/chirps/123", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /api/chirps/{chirpID}" {
+		t.Errorf("Expected the span to be named after the route, got %q", span.Name)
+	}
+	if span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace to continue from traceparent, got %s", span.SpanContext.TraceID())
+	}
+	if span.Parent.SpanID().String() != "00f067aa0ba902b7" || !span.Parent.IsRemote() {
+		t.Errorf("Expected the caller's span to be the parent, got %s", span.Parent.SpanID())
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("Expected the handler to see the request's span")
+	}
+	if attributeValue(span, "http.response.status_code").AsInt64() != 500 || span.Status.Code != codes.Error {
+		t.Errorf("Expected a failed span with status 500")
+	}
+	if spans[1].Name != "GET unmatched" || spans[1].Parent.IsValid() {
+		t.Errorf("Expected an unmatched root span, got %q", spans[1].Name)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	provider, exporter := newTestProvider()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	request, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/v1/checkout/sessions", nil)
+	client := &http.Client{Transport: &Transport{Provider: provider}}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	response.Body.Close()
+	parent.End()
+
+	if request.Header.Get("traceparent") != "" {
+		t.Errorf("Expected the caller's request to be left unchanged")
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected the client span to be a child of the caller's span")
+	}
+	if !strings.Contains(traceparent, span.SpanContext.TraceID().String()+"-"+span.SpanContext.SpanID().String()) {
+		t.Errorf("Expected traceparent to name the client span, got %q", traceparent)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	provider, shutdown, err := Setup(context.Background(), Options{Exporter: ExporterStdout, Writer: buf})
+	if err != nil {
+		t.Fatalf("Error setting up tracing: %v", err)
+	}
+	_, span := provider.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Error shutting down: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"work"`) || !strings.Contains(buf.String(), "chirpy") {
+		t.Errorf("Expected the span to be written, got %q", buf.String())
+	}
+
+	_, _, err = Setup(context.Background(), Options{Exporter: "zipkin"})
+	if err == nil {
+		t.Errorf("Expected an unknown exporter to be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+	now := time.Unix(1700000000, 0)
+
+	header := Sign(secret, now, body)
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Errorf("Expected header with timestamp and v1 signature, got %q", header)
+	}
+
+	err := Verify(secret, header, body, now.Add(time.Minute), DefaultTolerance)
+	if err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	err = Verify(secret, header, []byte(`{"id":"evt_1","event":"user.downgraded"}`), now, DefaultTolerance)
+	if !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Expected ErrSignatureMismatch for tampered body, got %v", err)
+	}
+
+	err = Verify("other", header, body, now, DefaultTolerance)
+	if !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Expected ErrSignatureMismatch for wrong secret, got %v", err)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	body := []byte(`{}`)
+	signedAt := time.Unix(1700000000, 0)
+	header := Sign("secret", signedAt, body)
+
+	err := Verify("secret", header, body, signedAt.Add(DefaultTolerance+time.Second), DefaultTolerance)
+	if !errors.Is(err, ErrTimestampOutOfRange) {
+		t.Errorf("Expected ErrTimestampOutOfRange for old delivery, got %v", err)
+	}
+	err = Verify("secret", header, body, signedAt.Add(-DefaultTolerance-time.Second), DefaultTolerance)
+	if !errors.Is(err, ErrTimestampOutOfRange) {
+		t.Errorf("Expected ErrTimestampOutOfRange for future delivery, got %v", err)
+	}
+
+	// Moving the timestamp forward invalidates the signature.
+	forged := strings.Replace(header, "t=1700000000", "t=1700000600", 1)
+	err = Verify("secret", forged, body, signedAt.Add(10*time.Minute), DefaultTolerance)
+	if !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Expected ErrSignatureMismatch for altered timestamp, got %v", err)
+	}
+}
+
+func TestVerifyRotation(t *testing.T) {
+	body := []byte(`{}`)
+	now := time.Unix(1700000000, 0)
+	oldHeader := Sign("old", now, body)
+	newHeader := Sign("new", now, body)
+	header := newHeader + "," + strings.SplitN(oldHeader, ",", 2)[1]
+
+	err := Verify("old", header, body, now, DefaultTolerance)
+	if err != nil {
+		t.Errorf("Expected a match on the second signature, got %v", err)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	tests := map[string]error{
+		"":                   ErrMissingSignature,
+		"garbage":            ErrMalformedSignature,
+		"t=abc,v1=00":        ErrMalformedSignature,
+		"t=1700000000":       ErrMalformedSignature,
+		"v1=00":              ErrMalformedSignature,
+		"t=1700000000,v1=zz": ErrMalformedSignature,
+	}
+	for header, expected := range tests {
+		err := Verify("secret", header, nil, time.Unix(1700000000, 0), DefaultTolerance)
+		if !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, header, err)
+		}
+	}
+}
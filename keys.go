@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"time"
+)
+
+// Signing keys
+const accessTokenLifetime = time.Hour
+
+func signingKeyFromDb(dbKey database.SigningKey) (*auth.SigningKey, error) {
+	key, err := auth.ParseSigningKey(dbKey.ID, dbKey.Algorithm, dbKey.PrivateKey, dbKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if dbKey.RetiredAt.Valid {
+		key.RetiredAt = dbKey.RetiredAt.Time
+	}
+	return key, nil
+}
+
+// loadSigningKeys fills the keyring from the database, generating a first key
+// if none exists yet.
+func (cfg *apiConfig) loadSigningKeys(ctx context.Context) error {
+	active, err := cfg.syncSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if active == nil {
+		_, err = cfg.rotateSigningKey(ctx)
+		return err
+	}
+	return nil
+}
+
+// syncSigningKeys brings the keyring up to date with the database, picking up
+// keys rotated in by other replicas, and evicts keys retired for longer than
+// tokens signed with them can be valid. It returns the active key, or nil if
+// there isn't one yet.
+func (cfg *apiConfig) syncSigningKeys(ctx context.Context) (*auth.SigningKey, error) {
+	dbKeys, err := cfg.db.GetSigningKeys(ctx, accessTokenLifetime.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	var active *auth.SigningKey
+	for _, dbKey := range dbKeys {
+		key, err := signingKeyFromDb(dbKey)
+		if err != nil {
+			return nil, err
+		}
+		if dbKey.RetiredAt.Valid {
+			cfg.keyring.Add(key)
+		} else {
+			active = key
+		}
+	}
+
+	if active != nil {
+		current := cfg.keyring.Active()
+		if current == nil || current.ID != active.ID {
+			cfg.keyring.SetActive(active)
+		}
+	}
+	cfg.keyring.Prune(time.Now())
+	return active, nil
+}
+
+// refreshSigningKeys is the signingkeys worker's job.
+func (cfg *apiConfig) refreshSigningKeys(ctx context.Context) error {
+	_, err := cfg.syncSigningKeys(ctx)
+	return err
+}
+
+func (cfg *apiConfig) fetchSigningKey(ctx context.Context, kid string) (*auth.SigningKey, error) {
+	dbKey, err := cfg.db.GetSigningKey(ctx, kid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrUnknownSigningKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dbKey.RetiredAt.Valid && time.Since(dbKey.RetiredAt.Time) > accessTokenLifetime {
+		return nil, auth.ErrUnknownSigningKey
+	}
+	return signingKeyFromDb(dbKey)
+}
+
+// rotateSigningKey retires the current key and activates a freshly generated one.
+func (cfg *apiConfig) rotateSigningKey(ctx context.Context) (*auth.SigningKey, error) {
+	key, err := auth.GenerateSigningKey(cfg.signingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.RetireSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = qtx.CreateSigningKey(ctx, database.CreateSigningKeyParams{
+		ID: key.ID, CreatedAt: key.CreatedAt, Algorithm: key.Algorithm, PrivateKey: der,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.keyring.SetActive(key)
+	return key, nil
+}
+
+// jwksHandler serves the keys from memory, so the public endpoint never
+// touches the database. Keys rotated in by other replicas are picked up by
+// the signingkeys worker within a minute.
+func (cfg *apiConfig) jwksHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+	sendJsonSuccessResponse(writer, cfg.keyring.JWKS())
+}
+
+func (cfg *apiConfig) rotateSigningKeyHandler(writer http.ResponseWriter, request *http.Request) {
+	type rotateResponse struct {
+		KeyID     string    `json:"kid"`
+		Algorithm string    `json:"alg"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	signingKey, err := cfg.rotateSigningKey(request.Context())
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonCreatedResponse(writer, rotateResponse{KeyID: signingKey.ID, Algorithm: signingKey.Algorithm, CreatedAt: signingKey.CreatedAt})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"time"
+)
+
+// Magic link login
+const magicLinkLifetime = 15 * time.Minute
+
+func (cfg *apiConfig) requestMagicLinkHandler(writer http.ResponseWriter, request *http.Request) {
+	type magicLinkPostBody struct {
+		Email string `json:"email"`
+	}
+
+	params := magicLinkPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	email, err := mail.NormalizeAddress(params.Email)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	// As with password resets, the response never says whether the account
+	// exists, so requests over the limit are dropped rather than refused.
+	dbUser, err := cfg.db.GetUserByEmail(request.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// Links are limited per address rather than per caller, so one inbox
+	// can't be flooded from many clients. Taking from the bucket is atomic,
+	// so concurrent requests can't all slip under the limit.
+	policy := cfg.rateLimits.magicLinkEmail
+	result, err := cfg.rateLimitStore.Take(request.Context(), policy.Name+":email:"+email, policy)
+	if err != nil {
+		slog.ErrorContext(request.Context(), "Error checking rate limit", "policy", policy.Name, "err", err)
+	} else if !result.Allowed {
+		slog.WarnContext(request.Context(), "Dropping rate limited magic link request", "user_id", dbUser.ID)
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.CreateMagicLinkToken(request.Context(), database.CreateMagicLinkTokenParams{
+		UserID: dbUser.ID, Email: email, TokenHash: auth.HashToken(token), ExpiresAt: time.Now().Add(magicLinkLifetime),
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	link := cfg.publicURL + "/app/magic-login?token=" + url.QueryEscape(token)
+	err = enqueueEmail(request.Context(), qtx, email, "Your Chirpy sign-in link", fmt.Sprintf(
+		"Open this link within %d minutes to sign in to Chirpy:\n\n%s\n\n"+
+			"The link works once. If you didn't ask to sign in, you can ignore this email.\n",
+		int(magicLinkLifetime.Minutes()), link,
+	))
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) consumeMagicLinkHandler(writer http.ResponseWriter, request *http.Request) {
+	type consumeMagicLinkPostBody struct {
+		Token string `json:"token"`
+	}
+
+	params := consumeMagicLinkPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	// The update only matches an unused token, so if two requests race with
+	// the same link only one of them gets a row back.
+	dbToken, err := cfg.db.UseMagicLinkToken(request.Context(), auth.HashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.metrics.Logins.WithLabelValues(loginMethodMagicLink, "failure").Inc()
+		sendJsonUnauthorizedError(writer, "Invalid or expired sign-in link")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), dbToken.UserID)
+	if err != nil {
+		sendJsonUnauthorizedError(writer, "Invalid or expired sign-in link")
+		return
+	}
+	// The link went to an address the account has since moved away from.
+	if dbUser.Email != dbToken.Email {
+		sendJsonUnauthorizedError(writer, "Invalid or expired sign-in link")
+		return
+	}
+
+	// Using the link proves the user can read mail sent to the address.
+	if !dbUser.VerifiedAt.Valid {
+		_, err = cfg.db.VerifyUserEmail(request.Context(), database.VerifyUserEmailParams{ID: dbUser.ID, Email: dbUser.Email})
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+	}
+
+	cfg.completeLogin(writer, request, dbUser, loginMethodMagicLink)
+}
@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/billing"
 	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/entitlements"
+	"pjh.id.au/chirpy/v2/internal/logging"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"pjh.id.au/chirpy/v2/internal/metrics"
+	"pjh.id.au/chirpy/v2/internal/passkey"
+	"pjh.id.au/chirpy/v2/internal/password"
+	"pjh.id.au/chirpy/v2/internal/ratelimit"
+	"pjh.id.au/chirpy/v2/internal/sso"
+	"pjh.id.au/chirpy/v2/internal/tracing"
 	"slices"
 	"strings"
 	"sync/atomic"
@@ -22,17 +35,48 @@ import _ "github.com/lib/pq"
 
 // API
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	authSecret     string
-	polkaKey       string
+	metrics          *metrics.Metrics
+	metricsToken     string
+	tracer           trace.TracerProvider
+	db               *database.Queries
+	dbConn           *sql.DB
+	keyring          *auth.Keyring
+	passkeys         *passkey.Service
+	passwords        *auth.PasswordHasher
+	passwordPolicy   password.Policy
+	ssoProviders     map[string]*sso.Provider
+	mailer           mail.Mailer
+	mailFrom         string
+	publicURL        string
+	signingAlgorithm string
+
+	requireVerifiedEmail bool
+	deletionGracePeriod  time.Duration
+	exportLinkLifetime   time.Duration
+
+	billingProviders        map[string]billing.Provider
+	checkoutProvider        billing.Provider
+	plans                   []billing.Plan
+	subscriptionGracePeriod time.Duration
+	rateLimitStore          ratelimit.Store
+	rateLimits              rateLimitPolicies
+	trustedProxies          []netip.Prefix
+
+	// draining is set once shutdown begins, to fail readiness checks.
+	draining atomic.Bool
+}
+
+// withTx returns queries that run in tx. Unlike cfg.db.WithTx, they are
+// traced like cfg.db.
+func (cfg *apiConfig) withTx(tx *sql.Tx) *database.Queries {
+	return database.New(tracing.WrapDB(tx, cfg.tracer))
 }
 
 func sendJsonResponse(writer http.ResponseWriter, response interface{}, status int) {
 	writer.Header().Add("Content-Type", "application/json")
 	jsonData, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Error marshalling JSON", "err", err)
 		writer.WriteHeader(500)
 		return
 	}
@@ -60,10 +104,49 @@ func sendJsonBadRequestError(writer http.ResponseWriter, error string) {
 	sendJsonError(writer, error, http.StatusBadRequest)
 }
 
+// FieldError explains why one field of a request body was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// sendJsonFieldErrors reports validation failures alongside the usual error
+// message, so clients can show each one next to the offending input.
+func sendJsonFieldErrors(writer http.ResponseWriter, fieldErrors []FieldError) {
+	type fieldErrorResponse struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	sendJsonResponse(writer, fieldErrorResponse{Error: fieldErrors[0].Message, Fields: fieldErrors}, http.StatusBadRequest)
+}
+
 func sendJsonUnauthorizedError(writer http.ResponseWriter, error string) {
 	sendJsonError(writer, error, http.StatusUnauthorized)
 }
 
+// sendJsonTokenError reports a rejected access token with the RFC 6750
+// WWW-Authenticate challenge matching the reason it was rejected.
+func sendJsonTokenError(writer http.ResponseWriter, err error) {
+	description := "The access token is invalid"
+	switch {
+	case errors.Is(err, auth.ErrTokenExpired):
+		description = "The access token has expired"
+	case errors.Is(err, auth.ErrTokenNotValidYet):
+		description = "The access token is not valid yet"
+	case errors.Is(err, auth.ErrTokenSignatureInvalid):
+		description = "The access token signature is invalid"
+	case errors.Is(err, auth.ErrTokenIssuer):
+		description = "The access token was issued by someone else"
+	case errors.Is(err, auth.ErrTokenAudience):
+		description = "The access token is for a different audience"
+	case errors.Is(err, auth.ErrTokenMalformed):
+		description = "The access token is malformed"
+	}
+	writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="invalid_token", error_description="%s"`, description))
+	sendJsonUnauthorizedError(writer, description)
+}
+
 func sendJsonForbiddenError(writer http.ResponseWriter, error string) {
 	sendJsonError(writer, error, http.StatusForbidden)
 }
@@ -72,6 +155,10 @@ func sendJsonNotFoundError(writer http.ResponseWriter, error string) {
 	sendJsonError(writer, error, http.StatusNotFound)
 }
 
+func sendJsonConflictError(writer http.ResponseWriter, error string) {
+	sendJsonError(writer, error, http.StatusConflict)
+}
+
 func sendJsonInternalServerError(writer http.ResponseWriter, error string) {
 	sendJsonError(writer, error, http.StatusInternalServerError)
 }
@@ -102,7 +189,7 @@ func healthHandler(writer http.ResponseWriter, request *http.Request) {
 // Metrics
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
+		cfg.metrics.CountFileserverHit()
 		next.ServeHTTP(w, r)
 	})
 }
@@ -110,7 +197,7 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 func (cfg *apiConfig) metricsHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	writer.WriteHeader(http.StatusOK)
-	writer.Write([]byte(fmt.Sprintf("Hits: %d", cfg.fileserverHits.Load())))
+	writer.Write([]byte(fmt.Sprintf("Hits: %d", cfg.metrics.FileserverHits())))
 }
 
 func (cfg *apiConfig) metricsResetHandler(writer http.ResponseWriter, request *http.Request) {
@@ -119,7 +206,7 @@ func (cfg *apiConfig) metricsResetHandler(writer http.ResponseWriter, request *h
 		return
 	}
 
-	cfg.fileserverHits.Store(0)
+	cfg.metrics.ResetFileserverHits()
 	err := cfg.db.DeleteAllUsers(request.Context())
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -134,32 +221,50 @@ func (cfg *apiConfig) metricsResetHandler(writer http.ResponseWriter, request *h
 func (cfg *apiConfig) metricsPageHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Add("Content-Type", "text/html")
 	writer.WriteHeader(http.StatusOK)
-	writer.Write([]byte(fmt.Sprintf("<html>\n  <body>\n    <h1>Welcome, Chirpy Admin</h1>\n    <p>Chirpy has been visited %d times!</p>\n  </body>\n</html>", cfg.fileserverHits.Load())))
+	writer.Write([]byte(fmt.Sprintf("<html>\n  <body>\n    <h1>Welcome, Chirpy Admin</h1>\n    <p>Chirpy has been visited %d times!</p>\n  </body>\n</html>", cfg.metrics.FileserverHits())))
 }
 
 // Users
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  *string   `json:"pending_email,omitempty"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Handle        *string   `json:"handle"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	AvatarURL     string    `json:"avatar_url"`
+	Location      string    `json:"location"`
+	Website       string    `json:"website"`
 }
 
 func UserFromDb(dbUser database.User) *User {
-	return &User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
+	user := &User{
+		ID:            dbUser.ID,
+		CreatedAt:     dbUser.CreatedAt,
+		UpdatedAt:     dbUser.UpdatedAt,
+		Email:         dbUser.Email,
+		EmailVerified: dbUser.VerifiedAt.Valid,
+		PendingEmail:  nullStringPtr(dbUser.PendingEmail),
+		IsChirpyRed:   dbUser.IsChirpyRed,
+		Handle:        nullStringPtr(dbUser.Handle),
+		DisplayName:   dbUser.DisplayName,
+		Bio:           dbUser.Bio,
+		AvatarURL:     dbUser.AvatarUrl,
+		Location:      dbUser.Location,
+		Website:       dbUser.Website,
 	}
+	return user
 }
 
 func (cfg *apiConfig) createUserHandler(writer http.ResponseWriter, request *http.Request) {
 	type createUserPostBody struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	params := createUserPostBody{}
@@ -169,17 +274,59 @@ func (cfg *apiConfig) createUserHandler(writer http.ResponseWriter, request *htt
 		return
 	}
 
-	password, err := auth.HashPassword(params.Password)
+	email, err := mail.NormalizeAddress(params.Email)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	fieldErrors := cfg.checkPasswordPolicy(params.Password, email)
+	handle := sql.NullString{}
+	if params.Handle != "" {
+		normalized, fieldErr := normalizeHandle(params.Handle)
+		if fieldErr != nil {
+			fieldErrors = append(fieldErrors, *fieldErr)
+		}
+		handle = sql.NullString{String: normalized, Valid: true}
+	}
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
+		return
+	}
+
+	password, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
 	if err != nil {
 		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
+	defer tx.Rollback()
 
-	dbUser, err := cfg.db.CreateUser(request.Context(), database.CreateUserParams{Email: params.Email, HashedPassword: password})
+	qtx := cfg.withTx(tx)
+	dbUser, err := qtx.CreateUser(request.Context(), database.CreateUserParams{Email: email, HashedPassword: password, Handle: handle})
+	if isHandleTaken(err) {
+		sendHandleTakenError(writer)
+		return
+	}
 	if err != nil {
 		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
+	err = cfg.enqueueVerificationEmail(request.Context(), qtx, dbUser.ID, dbUser.Email)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
 
 	user := UserFromDb(dbUser)
 
@@ -188,36 +335,73 @@ func (cfg *apiConfig) createUserHandler(writer http.ResponseWriter, request *htt
 
 func (cfg *apiConfig) updateUserHandler(writer http.ResponseWriter, request *http.Request) {
 	type updateUserPostBody struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recovery_code"`
 	}
 
-	jwt, err := auth.GetBearerToken(request.Header)
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	userId := principal.UserID
+
+	params := updateUserPostBody{}
+	err := decodePostBody(request.Body, &params)
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
 
-	userId, err := auth.ValidateJWT(jwt, cfg.authSecret)
+	email, err := mail.NormalizeAddress(params.Email)
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
 
-	params := updateUserPostBody{}
-	err = decodePostBody(request.Body, &params)
+	dbUser, err := cfg.db.GetUser(request.Context(), userId)
 	if err != nil {
-		sendJsonBadRequestError(writer, err.Error())
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	fieldErrors := cfg.checkPasswordPolicy(params.Password, email, dbUser.Email)
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
 		return
 	}
 
-	password, err := auth.HashPassword(params.Password)
+	// PUT always sends the password, so it only counts as a change if it
+	// differs from the current one. Changing either the email or the
+	// password has to be confirmed, as account deletion does.
+	_, err = cfg.passwords.Verify(params.Password, dbUser.HashedPassword)
+	if email != dbUser.Email || err != nil {
+		refusal, err := cfg.confirmAccountOwner(request.Context(), principal, dbUser, params.CurrentPassword, params.Code, params.RecoveryCode)
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+		if refusal != "" {
+			sendJsonForbiddenError(writer, refusal)
+			return
+		}
+	}
+
+	password, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
 		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
 
-	dbUser, err := cfg.db.UpdateUser(request.Context(), database.UpdateUserParams{ID: userId, Email: params.Email, HashedPassword: password})
+	// A new email address only takes effect once it has been confirmed.
+	if email != dbUser.Email {
+		err = cfg.startEmailChange(request.Context(), dbUser, email)
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+	}
+
+	dbUser, err = cfg.db.UpdateUser(request.Context(), database.UpdateUserParams{ID: userId, Email: dbUser.Email, HashedPassword: password})
 	if err != nil {
 		sendJsonBadRequestError(writer, err.Error())
 		return
@@ -228,20 +412,30 @@ func (cfg *apiConfig) updateUserHandler(writer http.ResponseWriter, request *htt
 	sendJsonSuccessResponse(writer, user)
 }
 
+// Login methods, as recorded in metrics.
+const (
+	loginMethodPassword  = "password"
+	loginMethodMagicLink = "magic_link"
+	loginMethodMFA       = "mfa"
+	loginMethodPasskey   = "passkey"
+	loginMethodOIDC      = "oidc"
+)
+
+type loginResponse struct {
+	Id           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Email        string    `json:"email"`
+	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+}
+
 func (cfg *apiConfig) loginHandler(writer http.ResponseWriter, request *http.Request) {
 	type loginPostBody struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	type loginResponse struct {
-		Id           uuid.UUID `json:"id"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Email        string    `json:"email"`
-		IsChirpyRed  bool      `json:"is_chirpy_red"`
-		Token        string    `json:"token"`
-		RefreshToken string    `json:"refresh_token"`
-	}
 
 	params := loginPostBody{}
 	err := decodePostBody(request.Body, &params)
@@ -250,21 +444,78 @@ func (cfg *apiConfig) loginHandler(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
-	dbUser, err := cfg.db.GetUserByEmail(request.Context(), params.Email)
+	email, err := mail.NormalizeAddress(params.Email)
 	if err != nil {
+		cfg.metrics.Logins.WithLabelValues(loginMethodPassword, "failure").Inc()
 		sendJsonUnauthorizedError(writer, "Incorrect email or password")
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, dbUser.HashedPassword)
+	dbUser, err := cfg.db.GetUserByEmail(request.Context(), email)
 	if err != nil {
+		cfg.metrics.Logins.WithLabelValues(loginMethodPassword, "failure").Inc()
 		sendJsonUnauthorizedError(writer, "Incorrect email or password")
 		return
 	}
 
+	err = cfg.checkPassword(request.Context(), dbUser, params.Password)
+	if err != nil {
+		cfg.metrics.Logins.WithLabelValues(loginMethodPassword, "failure").Inc()
+		sendJsonUnauthorizedError(writer, "Incorrect email or password")
+		return
+	}
+
+	cfg.completeLogin(writer, request, dbUser, loginMethodPassword)
+}
+
+// checkPassword verifies a user's password. Hashes made with bcrypt or
+// with weaker Argon2id parameters than currently configured are replaced
+// while the plaintext is at hand; a failed upgrade is logged but doesn't
+// stop the login.
+func (cfg *apiConfig) checkPassword(ctx context.Context, dbUser database.User, password string) error {
+	needsRehash, err := cfg.passwords.Verify(password, dbUser.HashedPassword)
+	if err != nil || !needsRehash {
+		return err
+	}
+
+	hash, err := cfg.passwords.Hash(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rehashing password", "user_id", dbUser.ID, "err", err)
+		return nil
+	}
+	// Matching on the old hash means a concurrent password change wins.
+	_, err = cfg.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		NewHash: hash, ID: dbUser.ID, OldHash: dbUser.HashedPassword,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rehashing password", "user_id", dbUser.ID, "err", err)
+	}
+	return nil
+}
+
+// completeLogin finishes a login once the user's first factor has been
+// checked, either issuing a session or challenging for a second factor.
+func (cfg *apiConfig) completeLogin(writer http.ResponseWriter, request *http.Request, dbUser database.User, method string) {
+	dbTotp, err := cfg.db.GetUserTOTP(request.Context(), dbUser.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if err == nil && dbTotp.EnabledAt.Valid {
+		cfg.metrics.Logins.WithLabelValues(method, "mfa_required").Inc()
+		cfg.sendMFAChallenge(writer, dbUser)
+		return
+	}
+
+	cfg.sendLoginResponse(writer, request, dbUser, method)
+}
+
+// sendLoginResponse issues a new session: an access token and a refresh
+// token. The method is only used for metrics.
+func (cfg *apiConfig) sendLoginResponse(writer http.ResponseWriter, request *http.Request, dbUser database.User, method string) {
 	user := UserFromDb(dbUser)
 
-	jwt, err := auth.MakeJWT(user.ID, cfg.authSecret, time.Duration(3600)*time.Second)
+	jwt, err := cfg.keyring.MakeJWT(auth.Principal{UserID: user.ID, Roles: dbUser.Roles, Scopes: auth.SessionScopes, AuthTime: time.Now()}, accessTokenLifetime)
 	if err != nil {
 		sendJsonInternalServerError(writer, err.Error())
 		return
@@ -282,6 +533,7 @@ func (cfg *apiConfig) loginHandler(writer http.ResponseWriter, request *http.Req
 		return
 	}
 
+	cfg.metrics.Logins.WithLabelValues(method, "success").Inc()
 	sendJsonSuccessResponse(writer, loginResponse{
 		Id:           user.ID,
 		CreatedAt:    user.CreatedAt,
@@ -314,8 +566,24 @@ func (cfg *apiConfig) refreshHandler(writer http.ResponseWriter, request *http.R
 		sendJsonUnauthorizedError(writer, "Unauthorized")
 		return
 	}
+	// Tokens issued to OAuth clients are refreshed at /oauth/token, where
+	// their scopes and client binding are enforced.
+	if dbRefreshToken.ClientID.Valid {
+		sendJsonUnauthorizedError(writer, "Unauthorized")
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), dbRefreshToken.UserID)
+	if err != nil {
+		sendJsonUnauthorizedError(writer, "Unauthorized")
+		return
+	}
 
-	token, err := auth.MakeJWT(dbRefreshToken.UserID, cfg.authSecret, time.Duration(3600)*time.Second)
+	// Session refresh tokens aren't rotated, so the token was created when
+	// the user logged in.
+	token, err := cfg.keyring.MakeJWT(auth.Principal{
+		UserID: dbUser.ID, Roles: dbUser.Roles, Scopes: auth.SessionScopes, AuthTime: dbRefreshToken.CreatedAt,
+	}, accessTokenLifetime)
 	if err != nil {
 		sendJsonInternalServerError(writer, err.Error())
 		return
@@ -342,11 +610,12 @@ func (cfg *apiConfig) revokeHandler(writer http.ResponseWriter, request *http.Re
 
 // Chirps
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserId    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Body      string         `json:"body"`
+	UserId    uuid.UUID      `json:"user_id"`
+	Author    *AuthorSummary `json:"author,omitempty"`
 }
 
 func ChirpFromDb(dbChirp database.Chirp) *Chirp {
@@ -359,9 +628,10 @@ func ChirpFromDb(dbChirp database.Chirp) *Chirp {
 	}
 }
 
-func validateChirp(body string) (string, error) {
-	if len(body) > 140 {
-		return "", errors.New("chirp is too long")
+func validateChirp(body string, allowed entitlements.Entitlements) (string, error) {
+	err := allowed.CheckChirpLength(body)
+	if err != nil {
+		return "", err
 	}
 
 	return replaceBannedWords(body), nil
@@ -377,8 +647,6 @@ func (cfg *apiConfig) getChirpsHandler(writer http.ResponseWriter, request *http
 		reverse = sortOrderQueryParam == "desc"
 	}
 
-	fmt.Println("reverse: ", reverse)
-
 	authorIdString := request.URL.Query().Get("author_id")
 	if authorIdString != "" {
 		authorId, err := uuid.Parse(authorIdString)
@@ -396,12 +664,14 @@ func (cfg *apiConfig) getChirpsHandler(writer http.ResponseWriter, request *http
 		dbChirps, err = cfg.db.GetChirps(request.Context(), reverse)
 		if err != nil {
 			sendJsonInternalServerError(writer, err.Error())
+			return
 		}
 	}
 
-	chirps := make([]*Chirp, 0, len(dbChirps))
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, ChirpFromDb(dbChirp))
+	chirps, err := cfg.chirpsWithAuthors(request.Context(), dbChirps)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
 	}
 
 	sendJsonSuccessResponse(writer, chirps)
@@ -424,9 +694,13 @@ func (cfg *apiConfig) getChirpHandler(writer http.ResponseWriter, request *http.
 		return
 	}
 
-	chirp := ChirpFromDb(dbChirp)
+	chirps, err := cfg.chirpsWithAuthors(request.Context(), []database.Chirp{dbChirp})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
 
-	sendJsonSuccessResponse(writer, chirp)
+	sendJsonSuccessResponse(writer, chirps[0])
 }
 
 func (cfg *apiConfig) createChirpHandler(writer http.ResponseWriter, request *http.Request) {
@@ -434,55 +708,83 @@ func (cfg *apiConfig) createChirpHandler(writer http.ResponseWriter, request *ht
 		Body string `json:"body"`
 	}
 
-	jwt, err := auth.GetBearerToken(request.Header)
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	userId := principal.UserID
+
+	params := createChirpPostBody{}
+	err := decodePostBody(request.Body, &params)
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
 
-	userId, err := auth.ValidateJWT(jwt, cfg.authSecret)
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
+	defer tx.Rollback()
 
-	params := createChirpPostBody{}
-	err = decodePostBody(request.Body, &params)
+	// Locking the user makes their posts queue up behind each other, so the
+	// daily quota can't be exceeded by posting several at once.
+	qtx := cfg.withTx(tx)
+	dbUser, err := qtx.GetUserForUpdate(request.Context(), userId)
 	if err != nil {
-		sendJsonBadRequestError(writer, err.Error())
+		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
+	if cfg.requireVerifiedEmail && !dbUser.VerifiedAt.Valid {
+		sendJsonForbiddenError(writer, "Verify your email address before posting chirps")
+		return
+	}
+	allowed := entitlementsFor(dbUser)
 
-	cleanBody, err := validateChirp(params.Body)
+	cleanBody, err := validateChirp(params.Body, allowed)
 	if err != nil {
 		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
 
-	dbChirp, err := cfg.db.CreateChirp(request.Context(), database.CreateChirpParams{Body: cleanBody, UserID: userId})
+	if allowed.DailyChirpLimit > 0 {
+		postedToday, err := qtx.CountChirpsByAuthorSince(request.Context(), database.CountChirpsByAuthorSinceParams{
+			UserID: userId, CreatedAt: time.Now().Add(-24 * time.Hour),
+		})
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+		err = allowed.CheckDailyQuota(postedToday)
+		if err != nil {
+			sendJsonError(writer, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	dbChirp, err := qtx.CreateChirp(request.Context(), database.CreateChirpParams{Body: cleanBody, UserID: userId})
 	if err != nil {
 		sendJsonBadRequestError(writer, err.Error())
 		return
 	}
-
-	chirp := ChirpFromDb(dbChirp)
-
-	sendJsonCreatedResponse(writer, chirp)
-}
-
-func (cfg *apiConfig) deleteChirpHandler(writer http.ResponseWriter, request *http.Request) {
-	jwt, err := auth.GetBearerToken(request.Header)
+	err = tx.Commit()
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
+	cfg.metrics.ChirpsCreated.Inc()
 
-	userId, err := auth.ValidateJWT(jwt, cfg.authSecret)
+	chirps, err := cfg.chirpsWithAuthors(request.Context(), []database.Chirp{dbChirp})
 	if err != nil {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
+		sendJsonInternalServerError(writer, err.Error())
 		return
 	}
 
+	sendJsonCreatedResponse(writer, chirps[0])
+}
+
+func (cfg *apiConfig) deleteChirpHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	userId := principal.UserID
+
 	id, err := uuid.Parse(request.PathValue("chirpID"))
 	if err != nil {
 		sendJsonBadRequestError(writer, err.Error())
@@ -516,104 +818,228 @@ func (cfg *apiConfig) deleteChirpHandler(writer http.ResponseWriter, request *ht
 	writer.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) polkaWebHookHandler(writer http.ResponseWriter, request *http.Request) {
-	type polkaWebHookPostBody struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
-	}
-
-	key, err := auth.GetAPIKey(request.Header)
-	if err != nil || key != cfg.polkaKey {
-		sendJsonUnauthorizedError(writer, "Unauthorized")
-		return
-	}
+func main() {
+	var err error
 
-	params := polkaWebHookPostBody{}
-	err = decodePostBody(request.Body, &params)
+	err = godotenv.Load()
 	if err != nil {
-		sendJsonBadRequestError(writer, err.Error())
-		return
+		fatal("Error loading .env file", "err", err)
 	}
 
-	if params.Event == "user.upgraded" {
-		userId, err := uuid.Parse(params.Data.UserID)
-		if err != nil {
-			sendJsonBadRequestError(writer, err.Error())
-			return
-		}
-		rows, err := cfg.db.UpgradeUser(request.Context(), userId)
-		if err != nil {
-			sendJsonBadRequestError(writer, err.Error())
-			return
-		}
-		if rows == 0 {
-			sendJsonBadRequestError(writer, "Upgrade failed. User not found?")
-		}
-
+	logger, err := newLoggerFromEnv()
+	if err != nil {
+		fatal("Error configuring logging", "err", err)
 	}
+	slog.SetDefault(logger)
 
-	writer.WriteHeader(http.StatusNoContent)
-}
-
-func main() {
-	var err error
-
-	err = godotenv.Load()
+	tracer, shutdownTracing, err := newTracerProviderFromEnv(context.Background())
 	if err != nil {
-		log.Fatal("Error loading .env file: ", err)
+		fatal("Error configuring tracing", "err", err)
 	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			slog.Error("Error flushing traces", "err", err)
+		}
+	}()
 
 	dbURL := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
-		log.Fatal("Error opening database: ", err)
+		fatal("Error opening database", "err", err)
 		return
 	}
 	defer func(db *sql.DB) {
 		err := db.Close()
 		if err != nil {
-			log.Fatal("Error closing database: ", err)
+			slog.Error("Error closing database", "err", err)
 		}
 	}(db)
-	dbQueries := database.New(db)
+	dbQueries := database.New(tracing.WrapDB(db, tracer))
 
 	mux := http.NewServeMux()
-	server := http.Server{
-		Handler: mux,
-		Addr:    ":8080",
-	}
 
 	authSecret := os.Getenv("AUTH_SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
+	signingAlgorithm := getEnvDefault("JWT_SIGNING_ALG", auth.AlgEdDSA)
+
+	apiCfg := apiConfig{
+		metrics:          metrics.New(),
+		metricsToken:     os.Getenv("METRICS_TOKEN"),
+		tracer:           tracer,
+		db:               dbQueries,
+		dbConn:           db,
+		keyring:          auth.NewKeyring(authSecret),
+		signingAlgorithm: signingAlgorithm,
+		mailer:           newMailerFromEnv(),
+		mailFrom:         getEnvDefault("MAIL_FROM", "Chirpy <no-reply@localhost>"),
+		publicURL:        strings.TrimSuffix(getEnvDefault("PUBLIC_URL", "http://localhost:8080"), "/"),
+
+		requireVerifiedEmail: getEnvBool("REQUIRE_VERIFIED_EMAIL", false),
+		deletionGracePeriod:  getEnvDuration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour),
+		exportLinkLifetime:   getEnvDuration("EXPORT_LINK_TTL", 48*time.Hour),
+
+		subscriptionGracePeriod: getEnvDuration("SUBSCRIPTION_GRACE_PERIOD", 3*24*time.Hour),
+	}
+	apiCfg.keyring.Fetch = apiCfg.fetchSigningKey
+	apiCfg.keyring.Retention = accessTokenLifetime
+	// The issuer is also the OAuth authorization server's, so by default it
+	// is the public URL. Tokens from before that still name "chirpy".
+	apiCfg.keyring.Options = auth.TokenOptions{
+		Issuer:          getEnvDefault("JWT_ISSUER", apiCfg.publicURL),
+		PreviousIssuers: []string{auth.DefaultIssuer},
+		Audience:        getEnvDefault("JWT_AUDIENCE", auth.DefaultAudience),
+		ClockSkew:       getEnvDuration("JWT_CLOCK_SKEW", 30*time.Second),
+	}
 
-	apiCfg := apiConfig{db: dbQueries, authSecret: authSecret, polkaKey: polkaKey}
+	apiCfg.passwords, err = auth.NewPasswordHasher(auth.Argon2Params{
+		Memory:      uint32(getEnvUint("ARGON2_MEMORY_KIB", uint64(auth.DefaultArgon2Params.Memory), 32)),
+		Iterations:  uint32(getEnvUint("ARGON2_ITERATIONS", uint64(auth.DefaultArgon2Params.Iterations), 32)),
+		Parallelism: uint8(getEnvUint("ARGON2_PARALLELISM", uint64(auth.DefaultArgon2Params.Parallelism), 8)),
+		SaltLength:  auth.DefaultArgon2Params.SaltLength,
+		KeyLength:   auth.DefaultArgon2Params.KeyLength,
+	})
+	if err != nil {
+		fatal("Error configuring password hashing", "err", err)
+	}
+
+	apiCfg.passwordPolicy, err = newPasswordPolicyFromEnv()
+	if err != nil {
+		fatal("Error configuring password policy", "err", err)
+	}
+
+	apiCfg.passkeys, err = passkey.New(passkey.Config{
+		RPID:          getEnvDefault("WEBAUTHN_RP_ID", "localhost"),
+		RPDisplayName: getEnvDefault("WEBAUTHN_RP_NAME", "Chirpy"),
+		RPOrigins:     strings.Split(getEnvDefault("WEBAUTHN_RP_ORIGINS", "http://localhost:8080"), ","),
+	})
+	if err != nil {
+		fatal("Error configuring WebAuthn", "err", err)
+	}
+
+	err = apiCfg.loadSigningKeys(context.Background())
+	if err != nil {
+		fatal("Error loading signing keys", "err", err)
+	}
+
+	err = apiCfg.loadSSOProviders(context.Background())
+	if err != nil {
+		fatal("Error configuring identity providers", "err", err)
+	}
+
+	err = apiCfg.loadBilling()
+	if err != nil {
+		fatal("Error configuring billing", "err", err)
+	}
+
+	err = apiCfg.loadRateLimits()
+	if err != nil {
+		fatal("Error configuring rate limits", "err", err)
+	}
+	limits := apiCfg.rateLimits
+
+	apiCfg.metrics.RegisterDB(db, "chirpy")
 
 	mux.HandleFunc("GET /api/healthz", healthHandler)
+	mux.HandleFunc("GET /api/readyz", apiCfg.readyHandler)
+	mux.Handle("GET /metrics", apiCfg.middlewareRequireMetricsAuth(apiCfg.metrics.Handler()))
 	mux.HandleFunc("GET /api/metrics", apiCfg.metricsHandler)
 	mux.HandleFunc("POST /api/reset", apiCfg.metricsResetHandler)
 	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsPageHandler)
 	mux.HandleFunc("POST /admin/reset", apiCfg.metricsResetHandler)
-
-	mux.HandleFunc("POST /api/users", apiCfg.createUserHandler)
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
-	mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
+	mux.Handle("POST /admin/keys/rotate", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.rotateSigningKeyHandler))
+
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.jwksHandler)
+
+	mux.Handle("POST /api/users", apiCfg.middlewareRateLimit(limits.signup, apiCfg.createUserHandler))
+	mux.Handle("PUT /api/users", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.updateUserHandler))
+	mux.Handle("PUT /api/users/profile", apiCfg.middlewareRequireAuth(auth.ScopeProfileWrite, apiCfg.updateProfileHandler))
+	mux.HandleFunc("GET /api/users/{idOrHandle}", apiCfg.getProfileHandler)
+	mux.Handle("GET /api/users/me", apiCfg.middlewareRequireAuth(auth.ScopeProfileWrite, apiCfg.getCurrentUserHandler))
+	mux.Handle("PATCH /api/users/me", apiCfg.middlewareRequireAuth(auth.ScopeProfileWrite, apiCfg.patchCurrentUserHandler))
+	mux.Handle("PUT /api/users/me/avatar", apiCfg.middlewareRequireAuth(auth.ScopeProfileWrite, apiCfg.putAvatarHandler))
+	mux.Handle("DELETE /api/users/me/avatar", apiCfg.middlewareRequireAuth(auth.ScopeProfileWrite, apiCfg.deleteAvatarHandler))
+	mux.HandleFunc("GET /api/users/{userID}/avatar", apiCfg.getAvatarHandler)
+	mux.Handle("DELETE /api/users/me", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.deleteCurrentUserHandler))
+	mux.Handle("DELETE /api/users/me/deletion", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.cancelUserDeletionHandler))
+	mux.Handle("POST /api/users/me/export", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.requestDataExportHandler))
+	mux.Handle("GET /api/users/me/exports", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.getDataExportsHandler))
+	mux.Handle("GET /api/users/me/exports/{exportID}/download", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.downloadOwnDataExportHandler))
+	mux.Handle("GET /api/users/me/entitlements", apiCfg.middlewareRequireAuth(auth.ScopeChirpsRead, apiCfg.getEntitlementsHandler))
+	mux.Handle("GET /api/users/me/subscription", apiCfg.middlewareRequireAuth(auth.ScopeChirpsRead, apiCfg.getSubscriptionHandler))
+	mux.HandleFunc("GET /api/exports/{exportID}", apiCfg.downloadDataExportHandler)
+	mux.HandleFunc("POST /api/users/verify-email", apiCfg.verifyEmailHandler)
+	mux.Handle("POST /api/users/verify-email/resend", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.resendVerificationEmailHandler))
+	mux.Handle("POST /api/users/email", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.changeEmailHandler))
+	mux.HandleFunc("POST /api/users/email/confirm", apiCfg.confirmEmailChangeHandler)
+	mux.Handle("POST /api/login", apiCfg.middlewareRateLimit(limits.login, apiCfg.loginHandler))
+	mux.Handle("POST /api/login/mfa", apiCfg.middlewareRateLimit(limits.loginMFA, apiCfg.loginMFAHandler))
+	mux.Handle("POST /api/login/magic", apiCfg.middlewareRateLimit(limits.magicLink, apiCfg.requestMagicLinkHandler))
+	mux.Handle("POST /api/login/magic/consume", apiCfg.middlewareRateLimit(limits.magicLinkConsume, apiCfg.consumeMagicLinkHandler))
+	mux.Handle("POST /api/password/forgot", apiCfg.middlewareRateLimit(limits.password, apiCfg.forgotPasswordHandler))
+	mux.Handle("POST /api/password/reset", apiCfg.middlewareRateLimit(limits.password, apiCfg.resetPasswordHandler))
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/login", apiCfg.oidcLoginHandler)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", apiCfg.oidcCallbackHandler)
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
 	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
 
-	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpHandler)
-	mux.HandleFunc("POST /api/chirps", apiCfg.createChirpHandler)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpHandler)
-
+	mux.Handle("POST /api/mfa/totp", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.enrollTOTPHandler))
+	mux.Handle("POST /api/mfa/totp/verify", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.verifyTOTPHandler))
+	mux.Handle("DELETE /api/mfa/totp", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.disableTOTPHandler))
+
+	mux.Handle("POST /api/webauthn/register/begin", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.beginPasskeyRegistrationHandler))
+	mux.Handle("POST /api/webauthn/register/finish", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.finishPasskeyRegistrationHandler))
+	mux.Handle("GET /api/webauthn/credentials", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.getPasskeysHandler))
+	mux.Handle("DELETE /api/webauthn/credentials/{credentialID}", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.deletePasskeyHandler))
+	mux.Handle("POST /api/webauthn/login/begin", apiCfg.middlewareRateLimit(limits.passkey, apiCfg.beginPasskeyLoginHandler))
+	mux.Handle("POST /api/webauthn/login/finish", apiCfg.middlewareRateLimit(limits.passkey, apiCfg.finishPasskeyLoginHandler))
+
+	mux.Handle("POST /api/tokens", apiCfg.middlewareRequireAuth(auth.ScopeTokensManage, apiCfg.createTokenHandler))
+	mux.Handle("GET /api/tokens", apiCfg.middlewareRequireAuth(auth.ScopeTokensManage, apiCfg.getTokensHandler))
+	mux.Handle("DELETE /api/tokens/{tokenID}", apiCfg.middlewareRequireAuth(auth.ScopeTokensManage, apiCfg.revokeTokenHandler))
+
+	mux.Handle("GET /api/chirps", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, apiCfg.getChirpsHandler))
+	mux.Handle("GET /api/chirps/{chirpID}", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, apiCfg.getChirpHandler))
+	mux.Handle("POST /api/chirps", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.middlewareRateLimit(limits.chirps, apiCfg.createChirpHandler)))
+	mux.Handle("DELETE /api/chirps/{chirpID}", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.deleteChirpHandler))
+
+	mux.Handle("POST /api/oauth/clients", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.createOAuthClientHandler))
+	mux.Handle("GET /api/oauth/clients", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.getOAuthClientsHandler))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.deleteOAuthClientHandler))
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.authorizeHandler)
+	mux.Handle("POST /oauth/authorize", apiCfg.middlewareRateLimit(limits.oauthConsent, apiCfg.authorizeDecisionHandler))
+	mux.HandleFunc("POST /oauth/token", apiCfg.tokenHandler)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.revokeOAuthTokenHandler)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.introspectHandler)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.authorizationServerMetadataHandler)
+
+	mux.HandleFunc("GET /api/billing/plans", apiCfg.getPlansHandler)
+	mux.Handle("POST /api/billing/checkout", apiCfg.middlewareRequireAuth(auth.ScopeAccountManage, apiCfg.createCheckoutSessionHandler))
+	mux.HandleFunc("POST /api/billing/{provider}/webhooks", apiCfg.billingWebhookHandler)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.polkaWebHookHandler)
+	mux.Handle("GET /admin/webhooks/events", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.getWebhookEventsHandler))
+	mux.Handle("POST /admin/webhooks/events/{provider}/{eventID}/replay", apiCfg.middlewareRequireRole(auth.RoleAdmin, apiCfg.replayWebhookEventHandler))
 
 	fileHandler := http.FileServer(http.Dir("."))
 	mux.Handle("/app/", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(fileHandler)))
 
-	err = server.ListenAndServe()
+	workers := newWorkerGroup()
+	workers.start("outbox", getEnvDuration("OUTBOX_INTERVAL", 5*time.Second), apiCfg.deliverOutbox)
+	workers.start("cleanup", time.Hour, apiCfg.deleteExpiredTokens)
+	workers.start("exports", getEnvDuration("EXPORT_INTERVAL", 10*time.Second), apiCfg.processDataExports)
+	workers.start("purge", time.Hour, apiCfg.purgeDeletedUsers)
+	workers.start("signingkeys", time.Minute, apiCfg.refreshSigningKeys)
+	workers.start("ratelimits", 10*time.Minute, apiCfg.sweepRateLimits)
+	workers.start("subscriptions", getEnvDuration("SUBSCRIPTION_EXPIRY_INTERVAL", 15*time.Minute), apiCfg.expireSubscriptions)
+
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := newServer(requestCtx, ":8080", tracing.Middleware(tracer, mux, logging.Middleware(logger, apiCfg.metrics.Middleware(mux))))
+	err = apiCfg.listenAndServe(server, cancelRequests, workers, shutdownOptions{
+		DrainDelay: getEnvDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		Timeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	})
 	if err != nil {
-		log.Fatal("Error running server: ", err)
+		fatal("Error running server", "err", err)
 	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"time"
+)
+
+// Two-factor authentication
+const (
+	mfaChallengeLifetime = 5 * time.Minute
+	totpIssuer           = "Chirpy"
+)
+
+func (cfg *apiConfig) enrollTOTPHandler(writer http.ResponseWriter, request *http.Request) {
+	type enrollResponse struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+		QRCode     string `json:"qr_code"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbUser, err := cfg.db.GetUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	key, err := auth.GenerateTOTPKey(totpIssuer, dbUser.Email)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	qrCode, err := auth.TOTPQRCode(key.URI)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// Re-enrolling before verification replaces the pending secret, but an
+	// enabled secret has to be disabled first.
+	_, err = cfg.db.UpsertUserTOTP(request.Context(), database.UpsertUserTOTPParams{UserID: dbUser.ID, Secret: key.Secret})
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonConflictError(writer, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonCreatedResponse(writer, enrollResponse{
+		Secret:     key.Secret,
+		OtpauthURI: key.URI,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode),
+	})
+}
+
+func (cfg *apiConfig) verifyTOTPHandler(writer http.ResponseWriter, request *http.Request) {
+	type verifyPostBody struct {
+		Code string `json:"code"`
+	}
+	type verifyResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := verifyPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	dbTotp, err := cfg.db.GetUserTOTP(request.Context(), principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "Two-factor authentication has not been set up")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if dbTotp.EnabledAt.Valid {
+		sendJsonConflictError(writer, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := auth.ValidateTOTPCode(params.Code, dbTotp.Secret, time.Now(), dbTotp.LastUsedStep)
+	if !ok {
+		sendJsonBadRequestError(writer, "Incorrect code")
+		return
+	}
+
+	recoveryCodes, err := auth.MakeRecoveryCodes()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	rows, err := qtx.EnableUserTOTP(request.Context(), database.EnableUserTOTPParams{UserID: principal.UserID, LastUsedStep: step})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonConflictError(writer, "Two-factor authentication is already enabled")
+		return
+	}
+	err = replaceRecoveryCodes(request.Context(), qtx, principal.UserID, recoveryCodes)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, verifyResponse{RecoveryCodes: recoveryCodes})
+}
+
+func replaceRecoveryCodes(ctx context.Context, db *database.Queries, userID uuid.UUID, codes []string) error {
+	err := db.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		err = db.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID: userID, CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) disableTOTPHandler(writer http.ResponseWriter, request *http.Request) {
+	type disablePostBody struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := disablePostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(request.Context(), principal.UserID, params.Code, params.RecoveryCode)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if !ok {
+		sendJsonBadRequestError(writer, "Incorrect code")
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.DeleteUserTOTP(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = qtx.DeleteRecoveryCodes(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code. Both are single use.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, userID uuid.UUID, code string, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		rows, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID: userID, CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		})
+		return rows == 1, err
+	}
+
+	dbTotp, err := cfg.db.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !dbTotp.EnabledAt.Valid {
+		return false, nil
+	}
+
+	step, ok := auth.ValidateTOTPCode(code, dbTotp.Secret, time.Now(), dbTotp.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	// Record the step conditionally so two requests racing with the same
+	// code can't both succeed.
+	rows, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{UserID: userID, LastUsedStep: step})
+	return rows == 1, err
+}
+
+func (cfg *apiConfig) sendMFAChallenge(writer http.ResponseWriter, dbUser database.User) {
+	type challengeResponse struct {
+		MFARequired bool     `json:"mfa_required"`
+		MFAToken    string   `json:"mfa_token"`
+		Methods     []string `json:"methods"`
+	}
+
+	token, err := cfg.keyring.MakeJWTForAudience(auth.Principal{UserID: dbUser.ID}, auth.AudienceMFAChallenge, mfaChallengeLifetime)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, challengeResponse{MFARequired: true, MFAToken: token, Methods: []string{"totp", "recovery_code"}})
+}
+
+func (cfg *apiConfig) loginMFAHandler(writer http.ResponseWriter, request *http.Request) {
+	type loginMFAPostBody struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	params := loginMFAPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	claims, err := cfg.keyring.ParseJWTForAudience(request.Context(), params.MFAToken, auth.AudienceMFAChallenge)
+	if err != nil {
+		sendJsonUnauthorizedError(writer, "Invalid or expired MFA token")
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		sendJsonUnauthorizedError(writer, "Invalid or expired MFA token")
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(request.Context(), userID, params.Code, params.RecoveryCode)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if !ok {
+		cfg.metrics.Logins.WithLabelValues(loginMethodMFA, "failure").Inc()
+		sendJsonUnauthorizedError(writer, "Incorrect code")
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), userID)
+	if err != nil {
+		sendJsonUnauthorizedError(writer, "Invalid or expired MFA token")
+		return
+	}
+
+	cfg.sendLoginResponse(writer, request, dbUser, loginMethodMFA)
+}
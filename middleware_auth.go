@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/logging"
+	"time"
+)
+
+// Authentication
+func (cfg *apiConfig) authenticate(request *http.Request) (*auth.Principal, error) {
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		return nil, err
+	}
+	if auth.IsPersonalAccessToken(token) {
+		return cfg.authenticatePersonalAccessToken(request.Context(), token)
+	}
+	return cfg.keyring.Authenticate(request.Context(), token)
+}
+
+func (cfg *apiConfig) authenticatePersonalAccessToken(ctx context.Context, token string) (*auth.Principal, error) {
+	dbToken, err := cfg.db.GetPersonalAccessTokenByHash(ctx, auth.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dbToken.RevokedAt.Valid {
+		return nil, auth.ErrTokenInvalid
+	}
+	if dbToken.ExpiresAt.Valid && dbToken.ExpiresAt.Time.Before(time.Now()) {
+		return nil, auth.ErrTokenExpired
+	}
+
+	err = cfg.db.TouchPersonalAccessToken(ctx, dbToken.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording personal access token use", "err", err)
+	}
+
+	return &auth.Principal{UserID: dbToken.UserID, Scopes: dbToken.Scopes, TokenID: dbToken.ID.String()}, nil
+}
+
+func sendAuthError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNoAuthHeader):
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+		sendJsonUnauthorizedError(writer, "Unauthorized")
+	case errors.Is(err, auth.ErrMalformedAuthHeader):
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="invalid_request"`)
+		sendJsonBadRequestError(writer, "Malformed Authorization header")
+	case errors.Is(err, auth.ErrTokenInvalid), errors.Is(err, auth.ErrTokenExpired), errors.Is(err, auth.ErrTokenMalformed),
+		errors.Is(err, auth.ErrTokenSignatureInvalid), errors.Is(err, auth.ErrTokenNotValidYet),
+		errors.Is(err, auth.ErrTokenIssuer), errors.Is(err, auth.ErrTokenAudience):
+		sendJsonTokenError(writer, err)
+	default:
+		sendJsonInternalServerError(writer, err.Error())
+	}
+}
+
+// checkScope reports whether the principal may use a route needing scope,
+// sending a 403 if it can't. An empty scope means the route needs none.
+func checkScope(writer http.ResponseWriter, principal *auth.Principal, scope string) bool {
+	if scope == "" || principal.HasScope(scope) {
+		return true
+	}
+	writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope="%s"`, scope))
+	sendJsonForbiddenError(writer, fmt.Sprintf("Token is missing the %s scope", scope))
+	return false
+}
+
+// middlewareRequireAuth rejects requests without a valid access token
+// carrying scope and stores the caller's principal in the request context.
+func (cfg *apiConfig) middlewareRequireAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if err != nil {
+			sendAuthError(w, err)
+			return
+		}
+		if !checkScope(w, principal, scope) {
+			return
+		}
+		logging.SetUserID(r.Context(), principal.UserID.String())
+		next.ServeHTTP(w, r.WithContext(auth.ContextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+// middlewareOptionalAuth lets anonymous requests through, but a request that
+// does present credentials must present valid ones carrying scope.
+func (cfg *apiConfig) middlewareOptionalAuth(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if errors.Is(err, auth.ErrNoAuthHeader) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			sendAuthError(w, err)
+			return
+		}
+		if !checkScope(w, principal, scope) {
+			return
+		}
+		logging.SetUserID(r.Context(), principal.UserID.String())
+		next.ServeHTTP(w, r.WithContext(auth.ContextWithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return cfg.middlewareRequireAuth("", func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		if !principal.HasRole(role) {
+			sendJsonForbiddenError(w, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareRequireMetricsAuth lets a scraper in with METRICS_TOKEN, which
+// unlike an access token doesn't expire, and otherwise requires an admin.
+func (cfg *apiConfig) middlewareRequireMetricsAuth(next http.Handler) http.Handler {
+	requireAdmin := cfg.middlewareRequireRole(auth.RoleAdmin, next.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err == nil && cfg.metricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requireAdmin.ServeHTTP(w, r)
+	})
+}
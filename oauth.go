@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"pjh.id.au/chirpy/v2/internal/oauth"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuth authorization server
+const (
+	authorizationCodeLifetime = 5 * time.Minute
+	oauthRefreshTokenLifetime = 60 * 24 * time.Hour
+)
+
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsRead:   "Read chirps",
+	auth.ScopeChirpsWrite:  "Post and delete chirps as you",
+	auth.ScopeProfileWrite: "Change your profile",
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+  <head><title>Authorize {{.ClientName}}</title></head>
+  <body>
+    <h1>{{.ClientName}} wants to access your Chirpy account</h1>
+    <p>It will be able to:</p>
+    <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+    {{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+    <form method="post" action="/oauth/authorize">
+      {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+      {{end}}<label>Email <input type="email" name="email" autocomplete="username" required></label>
+      <label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+      <label>Two-factor code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label>
+      <button type="submit" name="decision" value="allow">Allow</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+  </body>
+</html>
+`))
+
+type authorizeRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization
+// request. Until the client and redirect URI are known to be good, errors
+// are shown to the user rather than redirected, so the endpoint can't be used
+// as an open redirector; the boolean reports whether an error may be sent
+// back to the client's redirect URI.
+func (cfg *apiConfig) parseAuthorizeRequest(ctx context.Context, params url.Values) (*authorizeRequest, bool, error) {
+	dbClient, err := cfg.db.GetOAuthClient(ctx, params.Get("client_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, oauth.NewError(oauth.ErrorInvalidClient, "unknown client_id")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" && len(dbClient.RedirectUris) == 1 {
+		redirectURI = dbClient.RedirectUris[0]
+	}
+	if !slices.Contains(dbClient.RedirectUris, redirectURI) {
+		return nil, false, oauth.NewError(oauth.ErrorInvalidRequest, "redirect_uri is not registered for this client")
+	}
+
+	authRequest := &authorizeRequest{client: dbClient, redirectURI: redirectURI, state: params.Get("state")}
+	if params.Get("response_type") != "code" {
+		return authRequest, true, oauth.NewError(oauth.ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	// OAuth 2.1 makes PKCE mandatory, and plain challenges give no protection
+	// if the request is observed.
+	authRequest.codeChallenge = params.Get("code_challenge")
+	if authRequest.codeChallenge == "" || params.Get("code_challenge_method") != "S256" {
+		return authRequest, true, oauth.NewError(oauth.ErrorInvalidRequest, "a code_challenge using S256 is required")
+	}
+	authRequest.scopes, err = oauth.ParseScope(params.Get("scope"), dbClient.Scopes)
+	if err != nil {
+		return authRequest, true, err
+	}
+	return authRequest, false, nil
+}
+
+func (cfg *apiConfig) redirectAuthorizeError(writer http.ResponseWriter, request *http.Request, authRequest *authorizeRequest, err error) {
+	oauthErr := &oauth.Error{}
+	if !errors.As(err, &oauthErr) {
+		oauthErr = oauth.NewError("server_error", "%s", err)
+	}
+	params := url.Values{"error": {oauthErr.Code}, "iss": {cfg.keyring.Options.Issuer}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if authRequest.state != "" {
+		params.Set("state", authRequest.state)
+	}
+	http.Redirect(writer, request, oauth.RedirectWithParams(authRequest.redirectURI, params), http.StatusSeeOther)
+}
+
+func (cfg *apiConfig) renderConsent(writer http.ResponseWriter, authRequest *authorizeRequest, params url.Values, status int, message string) {
+	scopes := make([]string, 0, len(authRequest.scopes))
+	for _, scope := range authRequest.scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+	hidden := map[string]string{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method"} {
+		hidden[name] = params.Get(name)
+	}
+
+	// The consent page must never be framed, or it could be clickjacked.
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("X-Frame-Options", "DENY")
+	writer.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	err := consentTemplate.Execute(writer, map[string]any{
+		"ClientName": authRequest.client.Name,
+		"Scopes":     scopes,
+		"Params":     hidden,
+		"Error":      message,
+	})
+	if err != nil {
+		slog.Error("Error rendering consent page", "err", err)
+	}
+}
+
+func (cfg *apiConfig) authorizeHandler(writer http.ResponseWriter, request *http.Request) {
+	params := request.URL.Query()
+	authRequest, redirect, err := cfg.parseAuthorizeRequest(request.Context(), params)
+	if err != nil && redirect {
+		cfg.redirectAuthorizeError(writer, request, authRequest, err)
+		return
+	}
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	cfg.renderConsent(writer, authRequest, params, http.StatusOK, "")
+}
+
+func (cfg *apiConfig) authorizeDecisionHandler(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+	params := request.PostForm
+
+	authRequest, redirect, err := cfg.parseAuthorizeRequest(request.Context(), params)
+	if err != nil && redirect {
+		cfg.redirectAuthorizeError(writer, request, authRequest, err)
+		return
+	}
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	if params.Get("decision") != "allow" {
+		cfg.redirectAuthorizeError(writer, request, authRequest, oauth.NewError(oauth.ErrorAccessDenied, "the user denied the request"))
+		return
+	}
+
+	userID, message, err := cfg.authenticateConsent(request.Context(), params)
+	if err != nil {
+		cfg.redirectAuthorizeError(writer, request, authRequest, err)
+		return
+	}
+	if message != "" {
+		cfg.renderConsent(writer, authRequest, params, http.StatusUnauthorized, message)
+		return
+	}
+
+	code, err := auth.MakeOpaqueToken()
+	if err != nil {
+		cfg.redirectAuthorizeError(writer, request, authRequest, err)
+		return
+	}
+	err = cfg.db.CreateOAuthAuthorizationCode(request.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      authRequest.client.ID,
+		UserID:        userID,
+		RedirectUri:   authRequest.redirectURI,
+		Scopes:        authRequest.scopes,
+		CodeChallenge: authRequest.codeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		cfg.redirectAuthorizeError(writer, request, authRequest, err)
+		return
+	}
+
+	redirectParams := url.Values{"code": {code}, "iss": {cfg.keyring.Options.Issuer}}
+	if authRequest.state != "" {
+		redirectParams.Set("state", authRequest.state)
+	}
+	http.Redirect(writer, request, oauth.RedirectWithParams(authRequest.redirectURI, redirectParams), http.StatusSeeOther)
+}
+
+// authenticateConsent checks the credentials entered on the consent page,
+// including the second factor when the user has one. A non-empty message
+// means the user should try again.
+func (cfg *apiConfig) authenticateConsent(ctx context.Context, params url.Values) (uuid.UUID, string, error) {
+	email, err := mail.NormalizeAddress(params.Get("email"))
+	if err != nil {
+		return uuid.Nil, "Incorrect email or password", nil
+	}
+	dbUser, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		return uuid.Nil, "Incorrect email or password", nil
+	}
+	err = cfg.checkPassword(ctx, dbUser, params.Get("password"))
+	if err != nil {
+		return uuid.Nil, "Incorrect email or password", nil
+	}
+
+	dbTotp, err := cfg.db.GetUserTOTP(ctx, dbUser.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, "", err
+	}
+	if err == nil && dbTotp.EnabledAt.Valid {
+		ok, err := cfg.checkSecondFactor(ctx, dbUser.ID, params.Get("code"), "")
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+		if !ok {
+			return uuid.Nil, "Incorrect two-factor code", nil
+		}
+	}
+
+	return dbUser.ID, "", nil
+}
+
+func sendOAuthError(writer http.ResponseWriter, err error) {
+	oauthErr := &oauth.Error{}
+	if !errors.As(err, &oauthErr) {
+		slog.Error("Error handling OAuth request", "err", err)
+		sendJsonResponse(writer, oauth.Error{Code: "server_error"}, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == oauth.ErrorInvalidClient {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		status = http.StatusUnauthorized
+	}
+	writer.Header().Set("Cache-Control", "no-store")
+	sendJsonResponse(writer, oauthErr, status)
+}
+
+// authenticateOAuthClient identifies the client calling the token,
+// revocation or introspection endpoint, using client_secret_basic,
+// client_secret_post, or for public clients just the client_id.
+func (cfg *apiConfig) authenticateOAuthClient(request *http.Request) (database.OauthClient, error) {
+	clientID, secret, basic := request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials first.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = request.PostForm.Get("client_id"), request.PostForm.Get("client_secret")
+	}
+
+	dbClient, err := cfg.db.GetOAuthClient(request.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, oauth.NewError(oauth.ErrorInvalidClient, "unknown client")
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+
+	if dbClient.SecretHash.Valid {
+		hash := auth.HashToken(secret)
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(dbClient.SecretHash.String)) != 1 {
+			return database.OauthClient{}, oauth.NewError(oauth.ErrorInvalidClient, "client authentication failed")
+		}
+	}
+	return dbClient, nil
+}
+
+func (cfg *apiConfig) tokenHandler(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		sendOAuthError(writer, oauth.NewError(oauth.ErrorInvalidRequest, "%s", err))
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		sendOAuthError(writer, err)
+		return
+	}
+
+	switch request.PostForm.Get("grant_type") {
+	case "authorization_code":
+		err = cfg.authorizationCodeGrant(writer, request, dbClient)
+	case "refresh_token":
+		err = cfg.refreshTokenGrant(writer, request, dbClient)
+	default:
+		err = oauth.NewError(oauth.ErrorUnsupportedGrantType, "grant_type must be authorization_code or refresh_token")
+	}
+	if err != nil {
+		sendOAuthError(writer, err)
+	}
+}
+
+func (cfg *apiConfig) authorizationCodeGrant(writer http.ResponseWriter, request *http.Request, dbClient database.OauthClient) error {
+	form := request.PostForm
+
+	// Marking the code used is conditional, so a code can't be redeemed
+	// twice even by racing requests.
+	codeHash := auth.HashToken(form.Get("code"))
+	dbCode, err := cfg.db.UseOAuthAuthorizationCode(request.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A code presented twice may have been intercepted, so whatever
+		// was issued for it the first time is revoked too.
+		err = cfg.db.RevokeOAuthAuthorizationCodeFamily(request.Context(), codeHash)
+		if err != nil {
+			return err
+		}
+		return oauth.NewError(oauth.ErrorInvalidGrant, "authorization code is invalid, expired or already used")
+	}
+	if err != nil {
+		return err
+	}
+	if dbCode.ClientID != dbClient.ID {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "authorization code was issued to another client")
+	}
+	if form.Get("redirect_uri") != "" && form.Get("redirect_uri") != dbCode.RedirectUri {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if !oauth.VerifyCodeChallenge(form.Get("code_verifier"), dbCode.CodeChallenge) {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "code_verifier does not match the code_challenge")
+	}
+
+	return cfg.sendOAuthTokens(writer, request, dbClient, dbCode.UserID, dbCode.Scopes, uuid.NullUUID{UUID: dbCode.FamilyID, Valid: true})
+}
+
+func (cfg *apiConfig) refreshTokenGrant(writer http.ResponseWriter, request *http.Request, dbClient database.OauthClient) error {
+	form := request.PostForm
+
+	dbRefreshToken, err := cfg.db.GetRefreshToken(request.Context(), form.Get("refresh_token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "refresh token is invalid")
+	}
+	if err != nil {
+		return err
+	}
+	if dbRefreshToken.ClientID.String != dbClient.ID {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "refresh token was issued to another client")
+	}
+	if dbRefreshToken.ExpiresAt.Before(time.Now()) {
+		return oauth.NewError(oauth.ErrorInvalidGrant, "refresh token has expired")
+	}
+
+	// A client may ask for fewer scopes than it was granted, never more.
+	scopes, err := oauth.ParseScope(form.Get("scope"), dbRefreshToken.Scopes)
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens are rotated on every use, as OAuth 2.1 requires for
+	// public clients.
+	rows, err := cfg.db.RevokeActiveRefreshToken(request.Context(), dbRefreshToken.Token)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// A rotated token being used again means more than one party
+		// holds it, so every token descended from the same authorization
+		// code is revoked. Tokens from before families have none.
+		if dbRefreshToken.FamilyID.Valid {
+			slog.WarnContext(request.Context(), "Refresh token reused", "client_id", dbClient.ID, "user_id", dbRefreshToken.UserID)
+			err = cfg.db.RevokeRefreshTokenFamily(request.Context(), dbRefreshToken.FamilyID)
+			if err != nil {
+				return err
+			}
+		}
+		return oauth.NewError(oauth.ErrorInvalidGrant, "refresh token has been revoked")
+	}
+
+	return cfg.sendOAuthTokens(writer, request, dbClient, dbRefreshToken.UserID, scopes, dbRefreshToken.FamilyID)
+}
+
+// sendOAuthTokens issues an access token and a refresh token in familyID, the
+// family of tokens rotated from one authorization code.
+func (cfg *apiConfig) sendOAuthTokens(writer http.ResponseWriter, request *http.Request, dbClient database.OauthClient, userID uuid.UUID, scopes []string, familyID uuid.NullUUID) error {
+	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	// Third-party tokens never carry the user's roles.
+	accessToken, err := cfg.keyring.MakeJWT(auth.Principal{UserID: userID, Scopes: scopes, ClientID: dbClient.ID}, accessTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.CreateOAuthRefreshToken(request.Context(), database.CreateOAuthRefreshTokenParams{
+		Token:    refreshToken,
+		UserID:   userID,
+		Secs:     oauthRefreshTokenLifetime.Seconds(),
+		ClientID: sql.NullString{String: dbClient.ID, Valid: true},
+		Scopes:   scopes,
+		FamilyID: familyID,
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+	sendJsonSuccessResponse(writer, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+	return nil
+}
+
+// revokeOAuthTokenHandler implements RFC 7009. Access tokens are short-lived
+// JWTs that can't be revoked individually, so only refresh tokens are acted
+// on; as the RFC requires, unknown tokens still get a 200.
+func (cfg *apiConfig) revokeOAuthTokenHandler(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		sendOAuthError(writer, oauth.NewError(oauth.ErrorInvalidRequest, "%s", err))
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		sendOAuthError(writer, err)
+		return
+	}
+
+	dbRefreshToken, err := cfg.db.GetRefreshToken(request.Context(), request.PostForm.Get("token"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendOAuthError(writer, err)
+		return
+	}
+	if err == nil && dbRefreshToken.ClientID.String == dbClient.ID {
+		_, err = cfg.db.RevokeActiveRefreshToken(request.Context(), dbRefreshToken.Token)
+		if err != nil {
+			sendOAuthError(writer, err)
+			return
+		}
+	}
+
+	writer.WriteHeader(http.StatusOK)
+}
+
+// introspectHandler implements RFC 7662. Clients can only introspect tokens
+// issued to them; anything else is reported as inactive.
+func (cfg *apiConfig) introspectHandler(writer http.ResponseWriter, request *http.Request) {
+	type introspectionResponse struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		Issuer    string `json:"iss,omitempty"`
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		sendOAuthError(writer, oauth.NewError(oauth.ErrorInvalidRequest, "%s", err))
+		return
+	}
+
+	dbClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		sendOAuthError(writer, err)
+		return
+	}
+
+	token := request.PostForm.Get("token")
+	writer.Header().Set("Cache-Control", "no-store")
+
+	claims, err := cfg.keyring.ParseJWT(request.Context(), token)
+	if err == nil {
+		if claims.ClientID != dbClient.ID {
+			sendJsonSuccessResponse(writer, introspectionResponse{Active: false})
+			return
+		}
+		sendJsonSuccessResponse(writer, introspectionResponse{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+			Issuer:    claims.Issuer,
+		})
+		return
+	}
+
+	dbRefreshToken, err := cfg.db.GetRefreshToken(request.Context(), token)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendOAuthError(writer, err)
+		return
+	}
+	if err != nil || dbRefreshToken.ClientID.String != dbClient.ID || dbRefreshToken.RevokedAt.Valid || dbRefreshToken.ExpiresAt.Before(time.Now()) {
+		sendJsonSuccessResponse(writer, introspectionResponse{Active: false})
+		return
+	}
+	sendJsonSuccessResponse(writer, introspectionResponse{
+		Active:    true,
+		Scope:     strings.Join(dbRefreshToken.Scopes, " "),
+		ClientID:  dbClient.ID,
+		Subject:   dbRefreshToken.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: dbRefreshToken.ExpiresAt.Unix(),
+		IssuedAt:  dbRefreshToken.CreatedAt.Unix(),
+	})
+}
+
+// authorizationServerMetadataHandler publishes RFC 8414 metadata.
+func (cfg *apiConfig) authorizationServerMetadataHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Cache-Control", "public, max-age=3600")
+	sendJsonSuccessResponse(writer, map[string]any{
+		"issuer":                                         cfg.keyring.Options.Issuer,
+		"authorization_endpoint":                         cfg.publicURL + "/oauth/authorize",
+		"token_endpoint":                                 cfg.publicURL + "/oauth/token",
+		"revocation_endpoint":                            cfg.publicURL + "/oauth/revoke",
+		"introspection_endpoint":                         cfg.publicURL + "/oauth/introspect",
+		"jwks_uri":                                       cfg.publicURL + "/.well-known/jwks.json",
+		"scopes_supported":                               auth.OAuthScopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
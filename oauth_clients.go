@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/oauth"
+	"strings"
+	"time"
+)
+
+// OAuth client registration
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+}
+
+func OAuthClientFromDb(dbClient database.OauthClient) *OAuthClient {
+	return &OAuthClient{
+		ClientID:     dbClient.ID,
+		CreatedAt:    dbClient.CreatedAt,
+		Name:         dbClient.Name,
+		RedirectURIs: dbClient.RedirectUris,
+		Scopes:       dbClient.Scopes,
+		Public:       !dbClient.SecretHash.Valid,
+	}
+}
+
+func (cfg *apiConfig) createOAuthClientHandler(writer http.ResponseWriter, request *http.Request) {
+	type createClientPostBody struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// Public clients, such as mobile and single-page apps, can't keep a
+		// secret and rely on PKCE alone.
+		Public bool `json:"public"`
+	}
+	type createClientResponse struct {
+		*OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := createClientPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		sendJsonBadRequestError(writer, "name is required")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		sendJsonBadRequestError(writer, "at least one redirect URI is required")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		err = oauth.ValidateRedirectURI(uri)
+		if err != nil {
+			sendJsonBadRequestError(writer, err.Error())
+			return
+		}
+	}
+	err = auth.ValidateScopes(params.Scopes, auth.OAuthScopes)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	clientID, err := oauth.MakeClientID()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	secret := ""
+	secretHash := sql.NullString{}
+	if !params.Public {
+		secret, err = oauth.MakeClientSecret()
+		if err != nil {
+			sendJsonInternalServerError(writer, err.Error())
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := cfg.db.CreateOAuthClient(request.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		OwnerID:      principal.UserID,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// As with personal access tokens, the secret is only ever shown here.
+	sendJsonCreatedResponse(writer, createClientResponse{OAuthClient: OAuthClientFromDb(dbClient), ClientSecret: secret})
+}
+
+func (cfg *apiConfig) getOAuthClientsHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbClients, err := cfg.db.GetOAuthClientsForOwner(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	clients := make([]*OAuthClient, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		clients = append(clients, OAuthClientFromDb(dbClient))
+	}
+
+	sendJsonSuccessResponse(writer, clients)
+}
+
+func (cfg *apiConfig) deleteOAuthClientHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	// Deleting a client cascades to its codes and refresh tokens.
+	rows, err := cfg.db.DeleteOAuthClient(request.Context(), database.DeleteOAuthClientParams{
+		ID: request.PathValue("clientID"), OwnerID: principal.UserID,
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonNotFoundError(writer, "Client not found.")
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
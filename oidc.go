@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"pjh.id.au/chirpy/v2/internal/sso"
+	"pjh.id.au/chirpy/v2/internal/tracing"
+	"strings"
+	"time"
+)
+
+// OpenID Connect login
+const (
+	oidcLoginLifetime = 10 * time.Minute
+	oidcStateCookie   = "chirpy_oidc_state"
+)
+
+var (
+	errIdentityEmailUnverified = errors.New("the identity provider did not supply a verified email address")
+	errAccountEmailUnverified  = errors.New("an account with this email already exists; sign in and verify your email before linking")
+)
+
+// loadSSOProviders reads OIDC_PROVIDERS, a comma-separated list of names, and
+// for each name the OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optional _SCOPES variables.
+func (cfg *apiConfig) loadSSOProviders(ctx context.Context) error {
+	cfg.ssoProviders = map[string]*sso.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := sso.NewProvider(ctx, sso.ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  cfg.publicURL + "/api/auth/oidc/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+			TrustMFA:     getEnvBool(prefix+"TRUST_MFA", false),
+			Client:       &http.Client{Transport: &tracing.Transport{Provider: cfg.tracer}},
+		})
+		if err != nil {
+			return err
+		}
+		cfg.ssoProviders[name] = provider
+	}
+	return nil
+}
+
+func (cfg *apiConfig) oidcLoginHandler(writer http.ResponseWriter, request *http.Request) {
+	provider, ok := cfg.ssoProviders[request.PathValue("provider")]
+	if !ok {
+		sendJsonNotFoundError(writer, "Unknown identity provider")
+		return
+	}
+
+	login, err := sso.NewLoginState()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = cfg.db.CreateOIDCLoginState(request.Context(), database.CreateOIDCLoginStateParams{
+		State:        login.State,
+		Provider:     provider.Name,
+		Nonce:        login.Nonce,
+		CodeVerifier: login.CodeVerifier,
+		ExpiresAt:    time.Now().Add(oidcLoginLifetime),
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// The cookie ties the callback to the browser that started the login,
+	// so nobody can sign a victim in to the attacker's account.
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    login.State,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(writer, request, provider.AuthCodeURL(login), http.StatusFound)
+}
+
+func (cfg *apiConfig) oidcCallbackHandler(writer http.ResponseWriter, request *http.Request) {
+	provider, ok := cfg.ssoProviders[request.PathValue("provider")]
+	if !ok {
+		sendJsonNotFoundError(writer, "Unknown identity provider")
+		return
+	}
+
+	query := request.URL.Query()
+	if query.Get("error") != "" {
+		sendJsonUnauthorizedError(writer, fmt.Sprintf("Sign-in failed: %s %s", query.Get("error"), query.Get("error_description")))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := request.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		sendJsonBadRequestError(writer, "Invalid login state")
+		return
+	}
+	http.SetCookie(writer, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc/", MaxAge: -1})
+
+	dbState, err := cfg.db.ConsumeOIDCLoginState(request.Context(), database.ConsumeOIDCLoginStateParams{State: state, Provider: provider.Name})
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonBadRequestError(writer, "Invalid login state")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if dbState.ExpiresAt.Before(time.Now()) {
+		sendJsonBadRequestError(writer, "Login has expired, please try again")
+		return
+	}
+
+	identity, err := provider.Exchange(request.Context(), query.Get("code"), &sso.LoginState{
+		State: dbState.State, Nonce: dbState.Nonce, CodeVerifier: dbState.CodeVerifier,
+	})
+	if err != nil {
+		cfg.metrics.Logins.WithLabelValues(loginMethodOIDC, "failure").Inc()
+		sendJsonUnauthorizedError(writer, err.Error())
+		return
+	}
+
+	dbUser, err := cfg.userForIdentity(request.Context(), identity)
+	if errors.Is(err, errIdentityEmailUnverified) {
+		cfg.metrics.Logins.WithLabelValues(loginMethodOIDC, "failure").Inc()
+		sendJsonForbiddenError(writer, err.Error())
+		return
+	}
+	if errors.Is(err, errAccountEmailUnverified) {
+		cfg.metrics.Logins.WithLabelValues(loginMethodOIDC, "failure").Inc()
+		sendJsonConflictError(writer, err.Error())
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// Accounts with a second factor still need it, unless the provider has
+	// been configured as enforcing its own.
+	if provider.TrustMFA {
+		cfg.sendLoginResponse(writer, request, dbUser, loginMethodOIDC)
+		return
+	}
+	cfg.completeLogin(writer, request, dbUser, loginMethodOIDC)
+}
+
+// userForIdentity finds the user an external identity belongs to. The first
+// time an identity is seen it is linked by email, but only when both the
+// provider and Chirpy have verified that address; otherwise whoever signed up
+// with an unverified address first could take over the other account. If
+// nobody has the address yet, a new user is created.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, identity *sso.Identity) (database.User, error) {
+	dbIdentity, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{Provider: identity.Provider, Subject: identity.Subject})
+	if err == nil {
+		return cfg.db.GetUser(ctx, dbIdentity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if !identity.EmailVerified {
+		return database.User{}, errIdentityEmailUnverified
+	}
+	email, err := mail.NormalizeAddress(identity.Email)
+	if err != nil {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	dbUser, err := qtx.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		dbUser, err = cfg.createFederatedUser(ctx, qtx, email)
+	} else if err == nil && !dbUser.VerifiedAt.Valid {
+		err = errAccountEmailUnverified
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: identity.Provider, Subject: identity.Subject, UserID: dbUser.ID, Email: email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return dbUser, tx.Commit()
+}
+
+// createFederatedUser creates a user who signs in through an identity
+// provider. They get a random password they don't know, which they can
+// replace through a password reset.
+func (cfg *apiConfig) createFederatedUser(ctx context.Context, db *database.Queries, email string) (database.User, error) {
+	randomPassword, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	password, err := cfg.passwords.Hash(randomPassword)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	dbUser, err := db.CreateUser(ctx, database.CreateUserParams{Email: email, HashedPassword: password})
+	if err != nil {
+		return database.User{}, err
+	}
+	_, err = db.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: dbUser.ID, Email: email})
+	return dbUser, err
+}
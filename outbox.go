@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"time"
+)
+
+// Email outbox
+const (
+	outboxBatchSize   = 20
+	outboxMaxAttempts = 10
+	outboxMaxBackoff  = time.Hour
+	// outboxSendTimeout bounds each delivery, and outboxLease covers a
+	// whole batch of them.
+	outboxSendTimeout = 30 * time.Second
+	outboxLease       = 2 * outboxBatchSize * outboxSendTimeout
+	// outboxRetention is longer than any link sent by email stays valid.
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+func newMailerFromEnv() mail.Mailer {
+	transport := getEnvDefault("MAIL_TRANSPORT", "log")
+	switch transport {
+	case "smtp":
+		return &mail.SMTPMailer{
+			Addr:     getEnvDefault("SMTP_ADDR", "localhost:587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "file":
+		return &mail.FileMailer{Dir: getEnvDefault("MAIL_DIR", "mail")}
+	case "log":
+		// Not the structured logger: this transport is for development,
+		// where the links in emails need to be read unredacted.
+		return &mail.LogMailer{Logger: log.New(os.Stderr, "", log.LstdFlags)}
+	}
+	fatal("Unknown MAIL_TRANSPORT", "transport", transport)
+	return nil
+}
+
+// enqueueEmail records an email to be sent by the outbox worker. Pass the
+// transaction's queries so the email is only sent if the change commits.
+func enqueueEmail(ctx context.Context, db *database.Queries, to string, subject string, body string) error {
+	return db.EnqueueEmail(ctx, database.EnqueueEmailParams{Recipient: to, Subject: subject, Body: body})
+}
+
+// outboxBackoff doubles the delay after each failed attempt.
+func outboxBackoff(attempts int32) time.Duration {
+	backoff := time.Duration(math.Pow(2, float64(attempts))) * 30 * time.Second
+	return min(backoff, outboxMaxBackoff)
+}
+
+// deliverOutbox sends a batch of pending emails. They are claimed with a
+// lease, so several instances can run the worker safely, and each is marked
+// as soon as it has been tried, so a later failure can't undo the record of
+// one already delivered.
+func (cfg *apiConfig) deliverOutbox(ctx context.Context) error {
+	emails, err := cfg.db.ClaimPendingEmails(ctx, database.ClaimPendingEmailsParams{
+		Secs: outboxLease.Seconds(), Attempts: outboxMaxAttempts, Limit: outboxBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, email := range emails {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = cfg.deliverEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) deliverEmail(ctx context.Context, email database.EmailOutbox) error {
+	sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+	defer cancel()
+	err := cfg.mailer.Send(sendCtx, mail.Message{
+		ID:      email.ID.String(),
+		From:    cfg.mailFrom,
+		To:      email.Recipient,
+		Subject: email.Subject,
+		Body:    email.Body,
+	})
+
+	// The result is recorded even if the worker is stopping, since an email
+	// that went out but wasn't marked would be sent again.
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending email", "email_id", email.ID, "err", err)
+		return cfg.db.MarkEmailFailed(ctx, database.MarkEmailFailedParams{
+			ID:        email.ID,
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+			Secs:      outboxBackoff(email.Attempts).Seconds(),
+		})
+	}
+	return cfg.db.MarkEmailSent(ctx, email.ID)
+}
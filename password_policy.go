@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/password"
+)
+
+// Password policy
+func newPasswordPolicyFromEnv() (password.Policy, error) {
+	policy := password.Policy{
+		MinLength: int(getEnvUint("PASSWORD_MIN_LENGTH", uint64(password.DefaultPolicy.MinLength), 16)),
+		MaxLength: int(getEnvUint("PASSWORD_MAX_LENGTH", uint64(password.DefaultPolicy.MaxLength), 16)),
+		MinScore:  int(getEnvUint("PASSWORD_MIN_SCORE", uint64(password.DefaultPolicy.MinScore), 8)),
+	}
+
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path != "" {
+		breached, err := password.LoadBreachedList(path)
+		if err != nil {
+			return password.Policy{}, err
+		}
+		slog.Info("Loaded breached password hashes", "count", breached.Len(), "path", path)
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// checkPasswordPolicy returns the reasons a new password is unacceptable, if
+// any. emails are the user's current and requested addresses.
+func (cfg *apiConfig) checkPasswordPolicy(newPassword string, emails ...string) []FieldError {
+	violations := cfg.passwordPolicy.Check(newPassword, emails...)
+
+	fieldErrors := make([]FieldError, 0, len(violations))
+	for _, violation := range violations {
+		fieldErrors = append(fieldErrors, FieldError{Field: "password", Code: violation.Code, Message: violation.Message})
+	}
+	return fieldErrors
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/mail"
+	"time"
+)
+
+// Password reset
+const passwordResetLifetime = 30 * time.Minute
+
+func (cfg *apiConfig) forgotPasswordHandler(writer http.ResponseWriter, request *http.Request) {
+	type forgotPasswordPostBody struct {
+		Email string `json:"email"`
+	}
+
+	params := forgotPasswordPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	email, err := mail.NormalizeAddress(params.Email)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	// Answer the same way whether or not the account exists so this can't
+	// be used to find out who has signed up.
+	dbUser, err := cfg.db.GetUserByEmail(request.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	err = qtx.CreatePasswordResetToken(request.Context(), database.CreatePasswordResetTokenParams{
+		UserID: dbUser.ID, TokenHash: auth.HashToken(token), ExpiresAt: time.Now().Add(passwordResetLifetime),
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	link := cfg.publicURL + "/app/reset-password?token=" + url.QueryEscape(token)
+	err = enqueueEmail(request.Context(), qtx, dbUser.Email, "Reset your Chirpy password", fmt.Sprintf(
+		"Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, open this link within %d minutes:\n\n%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n",
+		int(passwordResetLifetime.Minutes()), link,
+	))
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) resetPasswordHandler(writer http.ResponseWriter, request *http.Request) {
+	type resetPasswordPostBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := resetPasswordPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(request.Context(), nil)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	// Marking the token used is conditional, so of two requests racing with
+	// the same token only one gets a row back.
+	qtx := cfg.withTx(tx)
+	userID, err := qtx.UsePasswordResetToken(request.Context(), auth.HashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonBadRequestError(writer, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	dbUser, err := qtx.GetUser(request.Context(), userID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// The policy needs the user's email, so it's checked once the token has
+	// been matched to a user. Rolling back leaves the token usable for
+	// another attempt.
+	fieldErrors := cfg.checkPasswordPolicy(params.Password, dbUser.Email)
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
+		return
+	}
+	password, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	err = qtx.UpdateUserPassword(request.Context(), database.UpdateUserPasswordParams{ID: userID, HashedPassword: password})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	// Sign out everywhere, in case the old password was known to someone else.
+	err = qtx.RevokeRefreshTokensForUser(request.Context(), userID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = qtx.DeletePasswordResetTokensForUser(request.Context(), userID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = enqueueEmail(request.Context(), qtx, dbUser.Email, "Your Chirpy password was changed",
+		"The password for your Chirpy account was just reset and you have been signed out of all sessions.\n\n"+
+			"If this wasn't you, reset your password again straight away.\n",
+	)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"net/http"
+	"net/url"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Profiles
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxLocationLength    = 30
+	maxWebsiteLength     = 100
+)
+
+// Handles can't contain hyphens, so they never parse as user IDs.
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles can't be claimed, either because they would be confused
+// with staff or the service itself, or because they collide with routes.
+var reservedHandles = map[string]bool{
+	"about": true, "admin": true, "administrator": true, "api": true, "app": true,
+	"chirpy": true, "help": true, "login": true, "logout": true, "me": true,
+	"moderator": true, "null": true, "oauth": true, "official": true, "root": true,
+	"security": true, "settings": true, "signup": true, "staff": true, "support": true,
+	"system": true, "undefined": true,
+}
+
+// Profile is the public view of a user. It never includes the email
+// address.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      *string   `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Location    string    `json:"location"`
+	Website     string    `json:"website"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+func ProfileFromDb(dbUser database.User) *Profile {
+	return &Profile{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt,
+		Handle:      nullStringPtr(dbUser.Handle),
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarURL:   dbUser.AvatarUrl,
+		Location:    dbUser.Location,
+		Website:     dbUser.Website,
+		IsChirpyRed: dbUser.IsChirpyRed,
+	}
+}
+
+// AuthorSummary is the part of a profile embedded in each chirp.
+type AuthorSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      *string   `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+func nullStringPtr(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// normalizeHandle strips a leading @ and checks the handle is well formed
+// and not reserved. Case is kept for display; uniqueness ignores it.
+func normalizeHandle(handle string) (string, *FieldError) {
+	handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+	if !handlePattern.MatchString(handle) {
+		return "", &FieldError{Field: "handle", Code: "invalid", Message: "Handle must be 3 to 30 letters, digits or underscores"}
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return "", &FieldError{Field: "handle", Code: "reserved", Message: "That handle is reserved"}
+	}
+	return handle, nil
+}
+
+func checkTextLength(field string, label string, value string, max int) *FieldError {
+	if utf8.RuneCountInString(value) > max {
+		return &FieldError{Field: field, Code: "too_long", Message: fmt.Sprintf("%s must be at most %d characters", label, max)}
+	}
+	return nil
+}
+
+func checkProfileURL(field string, label string, value string, max int) *FieldError {
+	if value == "" {
+		return nil
+	}
+	if len(value) > max {
+		return &FieldError{Field: field, Code: "too_long", Message: fmt.Sprintf("%s must be at most %d characters", label, max)}
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || parsed.User != nil {
+		return &FieldError{Field: field, Code: "invalid", Message: label + " must be an http or https URL"}
+	}
+	return nil
+}
+
+// profileUpdate holds the editable profile fields. The avatar is uploaded
+// separately.
+type profileUpdate struct {
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+}
+
+// validate trims and checks each field, returning the parameters for
+// UpdateUserProfile. An empty handle clears it.
+func (update profileUpdate) validate(userID uuid.UUID) (database.UpdateUserProfileParams, []FieldError) {
+	params := database.UpdateUserProfileParams{
+		ID:          userID,
+		DisplayName: strings.TrimSpace(update.DisplayName),
+		Bio:         strings.TrimSpace(update.Bio),
+		Location:    strings.TrimSpace(update.Location),
+		Website:     strings.TrimSpace(update.Website),
+	}
+
+	fieldErrors := []FieldError{}
+	if strings.TrimSpace(update.Handle) != "" {
+		handle, fieldErr := normalizeHandle(update.Handle)
+		if fieldErr != nil {
+			fieldErrors = append(fieldErrors, *fieldErr)
+		}
+		params.Handle = sql.NullString{String: handle, Valid: true}
+	}
+	for _, fieldErr := range []*FieldError{
+		checkTextLength("display_name", "Display name", params.DisplayName, maxDisplayNameLength),
+		checkTextLength("bio", "Bio", params.Bio, maxBioLength),
+		checkTextLength("location", "Location", params.Location, maxLocationLength),
+		checkProfileURL("website", "Website", params.Website, maxWebsiteLength),
+	} {
+		if fieldErr != nil {
+			fieldErrors = append(fieldErrors, *fieldErr)
+		}
+	}
+	return params, fieldErrors
+}
+
+func isHandleTaken(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_handle_key"
+}
+
+func sendHandleTakenError(writer http.ResponseWriter) {
+	sendJsonResponse(writer, map[string]any{
+		"error":  "That handle is already taken",
+		"fields": []FieldError{{Field: "handle", Code: "taken", Message: "That handle is already taken"}},
+	}, http.StatusConflict)
+}
+
+func (cfg *apiConfig) getProfileHandler(writer http.ResponseWriter, request *http.Request) {
+	idOrHandle := request.PathValue("idOrHandle")
+
+	var dbUser database.User
+	id, err := uuid.Parse(idOrHandle)
+	if err == nil {
+		dbUser, err = cfg.db.GetProfile(request.Context(), id)
+	} else {
+		dbUser, err = cfg.db.GetProfileByHandle(request.Context(), strings.TrimPrefix(idOrHandle, "@"))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "User not found.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, ProfileFromDb(dbUser))
+}
+
+func (cfg *apiConfig) updateProfileHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := profileUpdate{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	dbParams, fieldErrors := params.validate(principal.UserID)
+	if len(fieldErrors) > 0 {
+		sendJsonFieldErrors(writer, fieldErrors)
+		return
+	}
+
+	dbUser, err := cfg.db.UpdateUserProfile(request.Context(), dbParams)
+	if isHandleTaken(err) {
+		sendHandleTakenError(writer)
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, UserFromDb(dbUser))
+}
+
+// chirpsWithAuthors converts chirps for a response, looking up all their
+// authors in a single query. Authors whose accounts are waiting to be
+// deleted are left out.
+func (cfg *apiConfig) chirpsWithAuthors(ctx context.Context, dbChirps []database.Chirp) ([]*Chirp, error) {
+	seen := map[uuid.UUID]bool{}
+	authorIDs := []uuid.UUID{}
+	for _, dbChirp := range dbChirps {
+		if !seen[dbChirp.UserID] {
+			seen[dbChirp.UserID] = true
+			authorIDs = append(authorIDs, dbChirp.UserID)
+		}
+	}
+
+	authors := map[uuid.UUID]*AuthorSummary{}
+	if len(authorIDs) > 0 {
+		dbAuthors, err := cfg.db.GetAuthorSummaries(ctx, authorIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, dbAuthor := range dbAuthors {
+			authors[dbAuthor.ID] = &AuthorSummary{
+				ID:          dbAuthor.ID,
+				Handle:      nullStringPtr(dbAuthor.Handle),
+				DisplayName: dbAuthor.DisplayName,
+				AvatarURL:   dbAuthor.AvatarUrl,
+			}
+		}
+	}
+
+	chirps := make([]*Chirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirp := ChirpFromDb(dbChirp)
+		chirp.Author = authors[dbChirp.UserID]
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
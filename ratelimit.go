@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/ratelimit"
+	"strings"
+	"time"
+)
+
+// Rate limiting
+
+// rateLimitPolicies gives each step of logging in its own bucket, so a
+// login that takes several requests doesn't use up the allowance of
+// everyone else behind the same address.
+type rateLimitPolicies struct {
+	login            ratelimit.Policy
+	loginMFA         ratelimit.Policy
+	magicLink        ratelimit.Policy
+	magicLinkConsume ratelimit.Policy
+	magicLinkEmail   ratelimit.Policy
+	passkey          ratelimit.Policy
+	oauthConsent     ratelimit.Policy
+	signup           ratelimit.Policy
+	password         ratelimit.Policy
+	chirps           ratelimit.Policy
+}
+
+func (p rateLimitPolicies) all() []ratelimit.Policy {
+	return []ratelimit.Policy{
+		p.login, p.loginMFA, p.magicLink, p.magicLinkConsume, p.magicLinkEmail,
+		p.passkey, p.oauthConsent, p.signup, p.password, p.chirps,
+	}
+}
+
+// postgresRateLimitStore keeps buckets in the database, so limits hold
+// across replicas. Buckets are swept once idle for maxPeriod, the longest
+// period of any policy, by when any of them would have refilled.
+type postgresRateLimitStore struct {
+	db        *database.Queries
+	maxPeriod time.Duration
+}
+
+func (s *postgresRateLimitStore) Take(ctx context.Context, key string, policy ratelimit.Policy) (ratelimit.Result, error) {
+	row, err := s.db.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key: key, Capacity: float64(policy.Limit), Rate: policy.Rate(),
+	})
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return policy.Result(row.Tokens, row.Allowed), nil
+}
+
+func (s *postgresRateLimitStore) Sweep(ctx context.Context) error {
+	return s.db.DeleteStaleRateLimitBuckets(ctx, s.maxPeriod.Seconds())
+}
+
+// getRateLimitPolicy reads RATE_LIMIT_<NAME>, written like "10/1m".
+func getRateLimitPolicy(name string, fallback string) ratelimit.Policy {
+	envName := "RATE_LIMIT_" + strings.ToUpper(name)
+	policy, err := ratelimit.ParsePolicy(name, getEnvDefault(envName, fallback))
+	if err != nil {
+		fatal("Error parsing environment variable", "name", envName, "err", err)
+	}
+	return policy
+}
+
+// loadRateLimits reads RATE_LIMIT_STORE, either "memory" or "postgres",
+// TRUSTED_PROXIES and the per-route policies.
+func (cfg *apiConfig) loadRateLimits() error {
+	cfg.rateLimits = rateLimitPolicies{
+		login:    getRateLimitPolicy("login", "10/1m"),
+		loginMFA: getRateLimitPolicy("login_mfa", "10/1m"),
+		// Requests for links are also limited per address, separately from
+		// these per-client limits.
+		magicLink:        getRateLimitPolicy("magic_link", "10/1m"),
+		magicLinkConsume: getRateLimitPolicy("magic_link_consume", "10/1m"),
+		magicLinkEmail:   getRateLimitPolicy("magic_link_email", "3/15m"),
+		// A passkey login takes one request to begin and another to finish.
+		passkey:      getRateLimitPolicy("passkey", "20/1m"),
+		oauthConsent: getRateLimitPolicy("oauth_consent", "10/1m"),
+		signup:       getRateLimitPolicy("signup", "5/1h"),
+		password:     getRateLimitPolicy("password", "5/15m"),
+		chirps:       getRateLimitPolicy("chirps", "30/1m"),
+	}
+
+	storeName := getEnvDefault("RATE_LIMIT_STORE", "memory")
+	switch storeName {
+	case "memory":
+		cfg.rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		store := &postgresRateLimitStore{db: cfg.db}
+		for _, policy := range cfg.rateLimits.all() {
+			store.maxPeriod = max(store.maxPeriod, policy.Period)
+		}
+		cfg.rateLimitStore = store
+	default:
+		return fmt.Errorf("unknown RATE_LIMIT_STORE %q", storeName)
+	}
+
+	trustedProxies, err := ratelimit.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+	cfg.trustedProxies = trustedProxies
+	return nil
+}
+
+// rateLimitKey buckets signed-in callers by user, wherever they connect
+// from, and everyone else by address.
+func (cfg *apiConfig) rateLimitKey(request *http.Request, policy ratelimit.Policy) string {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if ok {
+		return policy.Name + ":user:" + principal.UserID.String()
+	}
+	ip := ratelimit.ClientIP(request, cfg.trustedProxies)
+	if ip.Is6() {
+		// One client usually has a whole /64.
+		ip = netip.PrefixFrom(ip, 64).Masked().Addr()
+	}
+	return policy.Name + ":ip:" + ip.String()
+}
+
+// middlewareRateLimit refuses requests over policy with a 429. Wrap it in
+// the auth middleware for routes that should be limited per user. If the
+// store fails the request is let through, since an outage of the limiter
+// shouldn't take the API down with it.
+func (cfg *apiConfig) middlewareRateLimit(policy ratelimit.Policy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := cfg.rateLimitStore.Take(r.Context(), cfg.rateLimitKey(r, policy), policy)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking rate limit", "policy", policy.Name, "err", err)
+			next(w, r)
+			return
+		}
+
+		result.SetHeaders(w.Header(), policy)
+		if !result.Allowed {
+			sendJsonError(w, fmt.Sprintf("Too many requests; try again in %s", max(time.Second, result.RetryAfter.Round(time.Second))), http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// sweepRateLimits forgets buckets that no longer hold any state.
+func (cfg *apiConfig) sweepRateLimits(ctx context.Context) error {
+	sweeper, ok := cfg.rateLimitStore.(interface{ Sweep(context.Context) error })
+	if !ok {
+		return nil
+	}
+	return sweeper.Sweep(ctx)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Server lifecycle
+const readinessTimeout = 2 * time.Second
+
+type shutdownOptions struct {
+	// DrainDelay is how long readiness fails before the server stops
+	// accepting connections, so load balancers stop sending it requests
+	// first.
+	DrainDelay time.Duration
+	// Timeout bounds waiting for in-flight requests to finish, and then
+	// again for background workers to stop.
+	Timeout time.Duration
+}
+
+// newServer applies the HTTP_* timeouts from the environment. Request
+// contexts derive from baseCtx, so cancelling it tells handlers that are
+// still running when the shutdown timeout expires to give up.
+func newServer(baseCtx context.Context, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+}
+
+// readyHandler reports whether this instance should be sent traffic. It
+// fails once shutdown has begun, and while the database is unreachable.
+// healthHandler only reports that the process is up.
+func (cfg *apiConfig) readyHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	if cfg.draining.Load() {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		writer.Write([]byte("Shutting down"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), readinessTimeout)
+	defer cancel()
+	err := cfg.dbConn.PingContext(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Database unreachable", "err", err)
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		writer.Write([]byte("Database unavailable"))
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write([]byte("OK"))
+}
+
+// shutdown fails readiness, stops the server once in-flight requests have
+// finished, and then stops the workers. Requests still running when the
+// timeout expires are cancelled and their connections closed.
+func (cfg *apiConfig) shutdown(server *http.Server, cancelRequests context.CancelFunc, workers *workerGroup, options shutdownOptions) {
+	cfg.draining.Store(true)
+	slog.Info("Draining", "delay", options.DrainDelay.String())
+	time.Sleep(options.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Timed out waiting for requests to finish", "err", err)
+		cancelRequests()
+		server.Close()
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+	err = workers.stop(ctx)
+	if err != nil {
+		slog.Warn("Timed out waiting for workers to stop", "err", err)
+	}
+}
+
+// listenAndServe runs server until SIGINT or SIGTERM, then shuts it down.
+// A second signal stops the process straight away.
+func (cfg *apiConfig) listenAndServe(server *http.Server, cancelRequests context.CancelFunc, workers *workerGroup, options shutdownOptions) error {
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("Listening", "addr", server.Addr)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopSignals()
+	slog.Info("Shutting down")
+	cfg.shutdown(server, cancelRequests, workers, options)
+	err := <-serveErr
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -7,8 +7,20 @@ VALUES ($1,
         NOW() + make_interval(secs => $3))
 RETURNING *;
 
+-- name: CreateOAuthRefreshToken :one
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, client_id, scopes, family_id)
+VALUES ($1,
+        NOW(),
+        NOW(),
+        $2,
+        NOW() + make_interval(secs => $3),
+        $4,
+        $5,
+        $6)
+RETURNING *;
+
 -- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scopes, family_id
 FROM refresh_tokens
 WHERE token = $1;
 
@@ -17,4 +29,31 @@ UPDATE refresh_tokens
 SET updated_at = NOW(),
     revoked_at = NOW()
 WHERE token = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: RevokeActiveRefreshToken :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE token = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE user_id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE family_id = $1
+  AND revoked_at IS NULL;
+
+-- name: GetRefreshTokensForUser :many
+SELECT *
+FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC;
@@ -0,0 +1,28 @@
+-- name: UpsertAvatar :one
+INSERT INTO avatars (user_id, updated_at, content_type, data)
+VALUES ($1,
+        NOW(),
+        $2,
+        $3)
+ON CONFLICT (user_id) DO UPDATE
+    SET updated_at   = excluded.updated_at,
+        content_type = excluded.content_type,
+        data         = excluded.data
+RETURNING *;
+
+-- name: GetAvatar :one
+SELECT *
+FROM avatars
+WHERE user_id = $1;
+
+-- name: GetPublicAvatar :one
+-- Avatars of accounts waiting to be deleted are hidden with their profiles.
+SELECT avatars.*
+FROM avatars
+         JOIN users ON users.id = avatars.user_id
+WHERE avatars.user_id = $1
+  AND users.delete_after IS NULL;
+
+-- name: DeleteAvatar :exec
+DELETE FROM avatars
+WHERE user_id = $1;
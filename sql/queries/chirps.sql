@@ -31,3 +31,9 @@ WHERE id = $1;
 DELETE FROM chirps
 WHERE id = $1
 AND user_id = $2;;
+
+-- name: CountChirpsByAuthorSince :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1
+  AND created_at >= $2;
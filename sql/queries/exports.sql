@@ -0,0 +1,73 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, user_id)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1)
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT *
+FROM data_exports
+WHERE id = $1;
+
+-- name: GetDataExportsForUser :many
+SELECT *
+FROM data_exports
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: GetUnfinishedDataExportForUser :one
+SELECT *
+FROM data_exports
+WHERE user_id = $1
+  AND status IN ('pending', 'processing')
+LIMIT 1;
+
+-- name: ClaimDataExport :one
+-- Exports stuck in processing, say because the server stopped part way,
+-- are picked up again after a while.
+UPDATE data_exports
+SET status     = 'processing',
+    started_at = NOW()
+WHERE id = (SELECT id
+            FROM data_exports
+            WHERE status = 'pending'
+               OR (status = 'processing' AND started_at < NOW() - make_interval(secs => @stale_secs::float8))
+            ORDER BY created_at
+            LIMIT 1 FOR UPDATE SKIP LOCKED)
+RETURNING *;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status       = 'ready',
+    completed_at = NOW(),
+    token_hash   = $2,
+    expires_at   = $3
+WHERE id = $1;
+
+-- name: SaveDataExportArchive :exec
+INSERT INTO data_export_archives (export_id, data)
+VALUES ($1, $2);
+
+-- name: GetDataExportArchive :one
+SELECT data
+FROM data_export_archives
+WHERE export_id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status       = 'failed',
+    completed_at = NOW(),
+    last_error   = $2
+WHERE id = $1;
+
+-- name: GetDataExportByTokenHash :one
+SELECT *
+FROM data_exports
+WHERE id = $1
+  AND token_hash = $2;
+
+-- name: DeleteExpiredDataExports :exec
+DELETE FROM data_exports
+WHERE expires_at < NOW()
+   OR (status = 'failed' AND completed_at < NOW() - INTERVAL '7 days');
@@ -0,0 +1,24 @@
+-- name: CreateSigningKey :one
+INSERT INTO signing_keys (id, created_at, algorithm, private_key)
+VALUES ($1,
+        $2,
+        $3,
+        $4)
+RETURNING *;
+
+-- name: GetSigningKey :one
+SELECT id, created_at, algorithm, private_key, retired_at
+FROM signing_keys
+WHERE id = $1;
+
+-- name: GetSigningKeys :many
+SELECT id, created_at, algorithm, private_key, retired_at
+FROM signing_keys
+WHERE retired_at IS NULL
+   OR retired_at > NOW() - make_interval(secs => $1)
+ORDER BY created_at;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys
+SET retired_at = NOW()
+WHERE retired_at IS NULL;
@@ -0,0 +1,20 @@
+-- name: CreateMagicLinkToken :exec
+INSERT INTO magic_link_tokens (id, created_at, user_id, email, token_hash, expires_at)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1,
+        $2,
+        $3,
+        $4);
+
+-- name: UseMagicLinkToken :one
+UPDATE magic_link_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING id, created_at, user_id, email, token_hash, expires_at, used_at;
+
+-- name: DeleteExpiredMagicLinkTokens :exec
+DELETE FROM magic_link_tokens
+WHERE expires_at < NOW() - INTERVAL '1 day';
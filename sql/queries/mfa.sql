@@ -0,0 +1,54 @@
+-- name: UpsertUserTOTP :one
+INSERT INTO user_totp (user_id, created_at, updated_at, secret)
+VALUES ($1,
+        NOW(),
+        NOW(),
+        $2)
+ON CONFLICT (user_id) DO UPDATE
+    SET updated_at     = NOW(),
+        secret         = EXCLUDED.secret,
+        last_used_step = 0
+    WHERE user_totp.enabled_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT user_id, created_at, updated_at, secret, enabled_at, last_used_step
+FROM user_totp
+WHERE user_id = $1;
+
+-- name: EnableUserTOTP :execrows
+UPDATE user_totp
+SET updated_at     = NOW(),
+    enabled_at     = NOW(),
+    last_used_step = $2
+WHERE user_id = $1
+  AND enabled_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET updated_at     = NOW(),
+    last_used_step = $2
+WHERE user_id = $1
+  AND last_used_step < $2;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, created_at, user_id, code_hash)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1,
+        $2);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+  AND code_hash = $2
+  AND used_at IS NULL;
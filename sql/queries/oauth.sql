@@ -0,0 +1,63 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, owner_id, name, secret_hash, redirect_uris, scopes)
+VALUES ($1,
+        NOW(),
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5,
+        $6)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT id, created_at, updated_at, owner_id, name, secret_hash, redirect_uris, scopes
+FROM oauth_clients
+WHERE id = $1;
+
+-- name: GetOAuthClientsForOwner :many
+SELECT id, created_at, updated_at, owner_id, name, secret_hash, redirect_uris, scopes
+FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1
+  AND owner_id = $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge,
+                                       expires_at)
+VALUES ($1,
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeOAuthAuthorizationCodeFamily :exec
+-- Revokes the refresh tokens issued from a code that has already been used,
+-- for when it is presented again.
+UPDATE refresh_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE family_id = (SELECT family_id
+                   FROM oauth_authorization_codes
+                   WHERE code_hash = $1
+                     AND used_at IS NOT NULL)
+  AND revoked_at IS NULL;
+
+-- name: DeleteExpiredOAuthAuthorizationCodes :exec
+DELETE FROM oauth_authorization_codes
+WHERE expires_at < NOW();
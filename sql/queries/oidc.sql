@@ -0,0 +1,32 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state, created_at, provider, nonce, code_verifier, expires_at)
+VALUES ($1,
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5);
+
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state = $1
+  AND provider = $2
+RETURNING state, created_at, provider, nonce, code_verifier, expires_at;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < NOW();
+
+-- name: GetUserIdentity :one
+SELECT provider, subject, created_at, user_id, email
+FROM user_identities
+WHERE provider = $1
+  AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+VALUES ($1,
+        $2,
+        NOW(),
+        $3,
+        $4);
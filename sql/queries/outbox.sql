@@ -0,0 +1,44 @@
+-- name: EnqueueEmail :exec
+INSERT INTO email_outbox (id, created_at, recipient, subject, body, next_attempt_at)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1,
+        $2,
+        $3,
+        NOW());
+
+-- name: ClaimPendingEmails :many
+-- Claimed emails are leased by pushing back their next attempt, so other
+-- workers leave them alone while they are sent. If the worker dies part
+-- way, they are picked up again once the lease runs out.
+UPDATE email_outbox
+SET next_attempt_at = NOW() + make_interval(secs => $1)
+WHERE id IN (SELECT id
+             FROM email_outbox
+             WHERE sent_at IS NULL
+               AND next_attempt_at <= NOW()
+               AND attempts < $2
+             ORDER BY created_at
+             LIMIT $3 FOR UPDATE SKIP LOCKED)
+RETURNING id, created_at, recipient, subject, body, attempts, next_attempt_at, last_error, sent_at;
+
+-- name: MarkEmailSent :exec
+-- The body is cleared once sent, since it can contain login and reset links.
+UPDATE email_outbox
+SET sent_at  = NOW(),
+    attempts = attempts + 1,
+    body     = ''
+WHERE id = $1;
+
+-- name: MarkEmailFailed :exec
+UPDATE email_outbox
+SET attempts        = attempts + 1,
+    last_error      = $2,
+    next_attempt_at = NOW() + make_interval(secs => $3)
+WHERE id = $1;
+
+-- name: DeleteOldEmails :exec
+-- Emails are deleted once they are older than any link they could contain,
+-- whether or not they were delivered.
+DELETE FROM email_outbox
+WHERE created_at < NOW() - make_interval(secs => $1);
@@ -0,0 +1,25 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (id, created_at, user_id, token_hash, expires_at)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1,
+        $2,
+        $3);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: DeletePasswordResetTokensForUser :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET updated_at = NOW(),
+    hashed_password = $2
+WHERE id = $1;
@@ -0,0 +1,21 @@
+-- name: TakeRateLimitToken :one
+-- Refills the bucket for the time since it was last used, then takes a
+-- token if there is a whole one. Doing both in one statement keeps
+-- concurrent requests from spending the same token.
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (@key, @capacity::float8 - 1, true, NOW())
+ON CONFLICT (key) DO UPDATE
+    SET tokens     = LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @rate::float8)
+                         - CASE
+                               WHEN LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @rate::float8) >= 1
+                                   THEN 1
+                               ELSE 0 END,
+        allowed    = LEAST(@capacity::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * @rate::float8) >= 1,
+        updated_at = NOW()
+RETURNING tokens, allowed;
+
+-- name: DeleteStaleRateLimitBuckets :exec
+-- A bucket left alone for the longest period of any policy has refilled,
+-- so it holds nothing a new bucket wouldn't.
+DELETE FROM rate_limit_buckets
+WHERE updated_at < NOW() - make_interval(secs => @max_period_secs::float8);
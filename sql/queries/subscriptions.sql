@@ -0,0 +1,58 @@
+-- name: ActivateSubscription :one
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+VALUES ($1,
+        NOW(),
+        NOW(),
+        $2,
+        'active',
+        $3)
+ON CONFLICT (user_id) DO UPDATE
+    SET updated_at           = NOW(),
+        plan                 = excluded.plan,
+        status               = 'active',
+        current_period_end   = excluded.current_period_end,
+        cancel_at_period_end = false,
+        canceled_at          = NULL,
+        grace_until          = NULL
+RETURNING *;
+
+-- name: RecordSubscriptionEvent :execrows
+-- Records that an event has set a subscription's period. No rows are
+-- affected if it already has.
+INSERT INTO subscription_events (provider, event_id, user_id, applied_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (provider, event_id) DO NOTHING;
+
+-- name: GetSubscription :one
+SELECT *
+FROM subscriptions
+WHERE user_id = $1;
+
+-- name: CancelSubscription :execrows
+UPDATE subscriptions
+SET updated_at           = NOW(),
+    status               = 'canceled',
+    cancel_at_period_end = true,
+    canceled_at          = NOW()
+WHERE user_id = $1
+  AND status IN ('active', 'past_due');
+
+-- name: MarkSubscriptionPastDue :execrows
+UPDATE subscriptions
+SET updated_at  = NOW(),
+    status      = 'past_due',
+    grace_until = COALESCE(grace_until, $2)
+WHERE user_id = $1
+  AND status IN ('active', 'past_due');
+
+-- name: ExpireLapsedSubscriptions :many
+-- Active memberships get a grace period past their period end, in case the
+-- renewal webhook is late; past-due ones run until their grace period ends;
+-- cancelled ones stop at the end of the period already paid for.
+UPDATE subscriptions
+SET updated_at = NOW(),
+    status     = 'expired'
+WHERE (status = 'active' AND current_period_end < NOW() - make_interval(secs => @grace_secs::float8))
+   OR (status = 'past_due' AND grace_until < NOW())
+   OR (status = 'canceled' AND current_period_end < NOW())
+RETURNING user_id;
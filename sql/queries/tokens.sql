@@ -0,0 +1,37 @@
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (id, created_at, updated_at, user_id, name, token_hash, token_prefix, scopes, expires_at)
+VALUES (gen_random_uuid(),
+        NOW(),
+        NOW(),
+        $1,
+        $2,
+        $3,
+        $4,
+        $5,
+        $6)
+RETURNING *;
+
+-- name: GetPersonalAccessTokenByHash :one
+SELECT id, created_at, updated_at, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, revoked_at
+FROM personal_access_tokens
+WHERE token_hash = $1;
+
+-- name: GetPersonalAccessTokensForUser :many
+SELECT id, created_at, updated_at, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, revoked_at
+FROM personal_access_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+ORDER BY created_at;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE id = $1
+  AND user_id = $2
+  AND revoked_at IS NULL;
+
+-- name: TouchPersonalAccessToken :exec
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE id = $1;
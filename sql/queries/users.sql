@@ -1,14 +1,20 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (gen_random_uuid(),
         NOW(),
         NOW(),
         $1,
-        $2)
+        $2,
+        $3)
 RETURNING *;
 
+-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1;
+
 -- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
 FROM users
 WHERE email = $1;
 
@@ -20,11 +26,102 @@ SET updated_at = NOW(),
 WHERE id = $1
 RETURNING *;
 
--- name: UpgradeUser :execrows
+-- name: SetUserChirpyRed :execrows
 UPDATE users
 SET updated_at = NOW(),
-    is_chirpy_red = true
+    is_chirpy_red = $2
 WHERE id = $1;
 
 -- name: DeleteAllUsers :exec
-DELETE FROM users;
\ No newline at end of file
+DELETE FROM users;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET updated_at = NOW(),
+    verified_at = COALESCE(verified_at, NOW())
+WHERE id = $1
+  AND email = $2;
+
+-- name: SetUserPendingEmail :exec
+UPDATE users
+SET updated_at = NOW(),
+    pending_email = $2
+WHERE id = $1;
+
+-- name: ConfirmUserEmailChange :one
+UPDATE users
+SET updated_at = NOW(),
+    email = pending_email,
+    pending_email = NULL,
+    verified_at = NOW()
+WHERE id = $1
+  AND pending_email = $2
+RETURNING *;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = @new_hash
+WHERE id = @id
+  AND hashed_password = @old_hash;
+
+-- name: GetProfile :one
+-- Profiles of accounts waiting to be deleted are hidden as if already gone.
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1
+  AND delete_after IS NULL;
+
+-- name: GetProfileByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE lower(handle) = lower($1)
+  AND delete_after IS NULL;
+
+-- name: GetAuthorSummaries :many
+SELECT id, handle, display_name, avatar_url
+FROM users
+WHERE id = ANY(@ids::uuid[])
+  AND delete_after IS NULL;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET updated_at = NOW(),
+    handle = $2,
+    display_name = $3,
+    bio = $4,
+    location = $5,
+    website = $6
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserAvatarURL :one
+UPDATE users
+SET updated_at = NOW(),
+    avatar_url = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUserForUpdate :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, roles, verified_at, pending_email, handle, display_name, bio, avatar_url, location, website, delete_after
+FROM users
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ScheduleUserDeletion :one
+UPDATE users
+SET updated_at   = NOW(),
+    delete_after = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET updated_at   = NOW(),
+    delete_after = NULL
+WHERE id = $1
+  AND delete_after IS NOT NULL;
+
+-- name: DeleteUsersPastGracePeriod :many
+DELETE FROM users
+WHERE delete_after <= NOW()
+RETURNING id;
@@ -0,0 +1,56 @@
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, name, public_key, attestation_type,
+                                  transports, aaguid, sign_count, backup_eligible, backup_state)
+VALUES ($1,
+        NOW(),
+        NOW(),
+        $2,
+        $3,
+        $4,
+        $5,
+        $6,
+        $7,
+        $8,
+        $9,
+        $10)
+RETURNING *;
+
+-- name: GetWebAuthnCredentialsForUser :many
+SELECT id, created_at, updated_at, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, last_used_at
+FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdateWebAuthnCredentialUse :execrows
+UPDATE webauthn_credentials
+SET updated_at   = NOW(),
+    last_used_at = NOW(),
+    sign_count   = $2,
+    backup_state = $3
+WHERE id = $1
+  AND (sign_count < $2 OR $2 = 0);
+
+-- name: DeleteWebAuthnCredential :execrows
+DELETE FROM webauthn_credentials
+WHERE id = $1
+  AND user_id = $2;
+
+-- name: CreateWebAuthnSession :one
+INSERT INTO webauthn_sessions (id, created_at, user_id, ceremony, data, expires_at)
+VALUES (gen_random_uuid(),
+        NOW(),
+        $1,
+        $2,
+        $3,
+        $4)
+RETURNING *;
+
+-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1
+  AND ceremony = $2
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthn_sessions
+WHERE expires_at < NOW();
@@ -0,0 +1,40 @@
+-- name: InsertWebhookEvent :execrows
+INSERT INTO webhook_events (id, provider, event_type, payload, received_at)
+VALUES ($1,
+        $2,
+        $3,
+        $4,
+        NOW())
+ON CONFLICT (provider, id) DO NOTHING;
+
+-- name: GetWebhookEventForUpdate :one
+SELECT *
+FROM webhook_events
+WHERE provider = $1
+  AND id = $2
+FOR UPDATE;
+
+-- name: GetWebhookEvents :many
+SELECT *
+FROM webhook_events
+WHERE NOT @unprocessed_only::boolean
+   OR processed_at IS NULL
+ORDER BY received_at DESC
+LIMIT $1;
+
+-- name: MarkWebhookEventProcessed :exec
+-- last_error is set for events that were skipped rather than applied, such
+-- as those for users that no longer exist.
+UPDATE webhook_events
+SET processed_at = NOW(),
+    attempts     = attempts + 1,
+    last_error   = $3
+WHERE provider = $1
+  AND id = $2;
+
+-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events
+SET attempts   = attempts + 1,
+    last_error = $3
+WHERE provider = $1
+  AND id = $2;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE signing_keys
+(
+    id          text PRIMARY KEY,
+    created_at  timestamp NOT NULL,
+    algorithm   text      NOT NULL,
+    private_key bytea     NOT NULL,
+    retired_at  timestamp
+);
+
+-- +goose Down
+DROP TABLE signing_keys;
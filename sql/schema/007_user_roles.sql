@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN roles text[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN roles;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE personal_access_tokens
+(
+    id           uuid PRIMARY KEY,
+    created_at   timestamp NOT NULL,
+    updated_at   timestamp NOT NULL,
+    user_id      uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         text      NOT NULL,
+    token_hash   text      NOT NULL UNIQUE,
+    token_prefix text      NOT NULL,
+    scopes       text[]    NOT NULL,
+    expires_at   timestamp,
+    last_used_at timestamp,
+    revoked_at   timestamp
+);
+
+CREATE INDEX idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
+
+-- +goose Down
+DROP TABLE personal_access_tokens;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE user_totp
+(
+    user_id        uuid PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    created_at     timestamp NOT NULL,
+    updated_at     timestamp NOT NULL,
+    secret         text      NOT NULL,
+    enabled_at     timestamp,
+    last_used_step bigint    NOT NULL DEFAULT 0
+);
+
+CREATE TABLE mfa_recovery_codes
+(
+    id         uuid PRIMARY KEY,
+    created_at timestamp NOT NULL,
+    user_id    uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  text      NOT NULL,
+    used_at    timestamp,
+    UNIQUE (user_id, code_hash)
+);
+
+-- +goose Down
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_totp;
@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE TABLE webauthn_credentials
+(
+    id               bytea PRIMARY KEY,
+    created_at       timestamp NOT NULL,
+    updated_at       timestamp NOT NULL,
+    user_id          uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             text      NOT NULL,
+    public_key       bytea     NOT NULL,
+    attestation_type text      NOT NULL,
+    transports       text[]    NOT NULL,
+    aaguid           bytea     NOT NULL,
+    sign_count       bigint    NOT NULL,
+    backup_eligible  boolean   NOT NULL,
+    backup_state     boolean   NOT NULL,
+    last_used_at     timestamp
+);
+
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+CREATE TABLE webauthn_sessions
+(
+    id         uuid PRIMARY KEY,
+    created_at timestamp NOT NULL,
+    user_id    uuid REFERENCES users (id) ON DELETE CASCADE,
+    ceremony   text      NOT NULL,
+    data       jsonb     NOT NULL,
+    expires_at timestamp NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_sessions;
+DROP TABLE webauthn_credentials;
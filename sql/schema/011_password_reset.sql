@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE password_reset_tokens
+(
+    id         uuid PRIMARY KEY,
+    created_at timestamp NOT NULL,
+    user_id    uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash text      NOT NULL UNIQUE,
+    expires_at timestamp NOT NULL,
+    used_at    timestamp
+);
+
+CREATE TABLE email_outbox
+(
+    id              uuid PRIMARY KEY,
+    created_at      timestamp NOT NULL,
+    recipient       text      NOT NULL,
+    subject         text      NOT NULL,
+    body            text      NOT NULL,
+    attempts        integer   NOT NULL DEFAULT 0,
+    next_attempt_at timestamp NOT NULL,
+    last_error      text,
+    sent_at         timestamp
+);
+
+CREATE INDEX idx_email_outbox_pending ON email_outbox (next_attempt_at) WHERE sent_at IS NULL;
+
+-- +goose Down
+DROP TABLE email_outbox;
+DROP TABLE password_reset_tokens;
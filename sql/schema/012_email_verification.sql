@@ -0,0 +1,36 @@
+-- +goose Up
+-- Addresses are compared normalised from now on. Accounts whose addresses
+-- only differ by case or surrounding spaces would collide, and which one to
+-- keep is for an operator to decide, so the migration stops and names them.
+-- +goose StatementBegin
+DO
+$$
+    DECLARE
+        duplicates text;
+    BEGIN
+        SELECT string_agg(normalized, ', ' ORDER BY normalized)
+        INTO duplicates
+        FROM (SELECT lower(trim(email)) AS normalized
+              FROM users
+              GROUP BY lower(trim(email))
+              HAVING count(*) > 1) AS conflicts;
+
+        IF duplicates IS NOT NULL THEN
+            RAISE EXCEPTION 'users share an email address once case and spaces are ignored: %', duplicates
+                USING HINT = 'Merge or rename these accounts, then run the migration again.';
+        END IF;
+    END
+$$;
+-- +goose StatementEnd
+
+UPDATE users
+SET email = lower(trim(email));
+
+ALTER TABLE users
+ADD COLUMN verified_at timestamp,
+ADD COLUMN pending_email text;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN pending_email,
+DROP COLUMN verified_at;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE magic_link_tokens
+(
+    id         uuid PRIMARY KEY,
+    created_at timestamp NOT NULL,
+    user_id    uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      text      NOT NULL,
+    token_hash text      NOT NULL UNIQUE,
+    expires_at timestamp NOT NULL,
+    used_at    timestamp
+);
+
+CREATE INDEX idx_magic_link_tokens_email ON magic_link_tokens (email, created_at);
+
+-- +goose Down
+DROP TABLE magic_link_tokens;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE user_identities
+(
+    provider   text      NOT NULL,
+    subject    text      NOT NULL,
+    created_at timestamp NOT NULL,
+    user_id    uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      text      NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE oidc_login_states
+(
+    state         text PRIMARY KEY,
+    created_at    timestamp NOT NULL,
+    provider      text      NOT NULL,
+    nonce         text      NOT NULL,
+    code_verifier text      NOT NULL,
+    expires_at    timestamp NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE user_identities;
@@ -0,0 +1,39 @@
+-- +goose Up
+CREATE TABLE oauth_clients
+(
+    id            text PRIMARY KEY,
+    created_at    timestamp NOT NULL,
+    updated_at    timestamp NOT NULL,
+    owner_id      uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name          text      NOT NULL,
+    secret_hash   text,
+    redirect_uris text[]    NOT NULL,
+    scopes        text[]    NOT NULL
+);
+
+CREATE INDEX idx_oauth_clients_owner_id ON oauth_clients (owner_id);
+
+CREATE TABLE oauth_authorization_codes
+(
+    code_hash      text PRIMARY KEY,
+    created_at     timestamp NOT NULL,
+    client_id      text      NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id        uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri   text      NOT NULL,
+    scopes         text[]    NOT NULL,
+    code_challenge text      NOT NULL,
+    expires_at     timestamp NOT NULL,
+    used_at        timestamp
+);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN client_id text REFERENCES oauth_clients (id) ON DELETE CASCADE,
+ADD COLUMN scopes text[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN scopes,
+DROP COLUMN client_id;
+
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle text,
+ADD COLUMN display_name text not null default '',
+ADD COLUMN bio text not null default '',
+ADD COLUMN avatar_url text not null default '',
+ADD COLUMN location text not null default '',
+ADD COLUMN website text not null default '';
+
+-- Handles are matched case-insensitively but displayed as the user chose.
+CREATE UNIQUE INDEX users_handle_key ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_key;
+
+ALTER TABLE users
+DROP COLUMN website,
+DROP COLUMN location,
+DROP COLUMN avatar_url,
+DROP COLUMN bio,
+DROP COLUMN display_name,
+DROP COLUMN handle;
@@ -0,0 +1,29 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN delete_after timestamp;
+
+CREATE INDEX idx_users_delete_after ON users (delete_after) WHERE delete_after IS NOT NULL;
+
+CREATE TABLE data_exports
+(
+    id           uuid PRIMARY KEY,
+    created_at   timestamp NOT NULL,
+    user_id      uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status       text      NOT NULL DEFAULT 'pending',
+    started_at   timestamp,
+    completed_at timestamp,
+    file_path    text,
+    token_hash   text UNIQUE,
+    expires_at   timestamp,
+    last_error   text
+);
+
+CREATE INDEX idx_data_exports_pending ON data_exports (created_at) WHERE status IN ('pending', 'processing');
+
+-- +goose Down
+DROP TABLE data_exports;
+
+DROP INDEX idx_users_delete_after;
+
+ALTER TABLE users
+DROP COLUMN delete_after;
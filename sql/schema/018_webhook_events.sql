@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE webhook_events
+(
+    id           text PRIMARY KEY,
+    provider     text      NOT NULL,
+    event_type   text      NOT NULL,
+    payload      jsonb     NOT NULL,
+    received_at  timestamp NOT NULL,
+    processed_at timestamp,
+    attempts     integer   NOT NULL DEFAULT 0,
+    last_error   text
+);
+
+CREATE INDEX idx_webhook_events_received_at ON webhook_events (received_at);
+
+-- +goose Down
+DROP TABLE webhook_events;
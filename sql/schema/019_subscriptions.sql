@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE subscriptions
+(
+    user_id              uuid PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    created_at           timestamp NOT NULL,
+    updated_at           timestamp NOT NULL,
+    plan                 text      NOT NULL,
+    status               text      NOT NULL,
+    current_period_end   timestamp NOT NULL,
+    cancel_at_period_end boolean   NOT NULL DEFAULT false,
+    canceled_at          timestamp,
+    grace_until          timestamp
+);
+
+CREATE INDEX idx_subscriptions_lapsing ON subscriptions (current_period_end) WHERE status <> 'expired';
+
+-- Existing members never had a period end recorded, so give them a month
+-- for Polka's next renewal to arrive.
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_end)
+SELECT id, NOW(), NOW(), 'red', 'active', NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Event IDs are only unique within one provider.
+ALTER TABLE webhook_events DROP CONSTRAINT webhook_events_pkey;
+ALTER TABLE webhook_events ADD PRIMARY KEY (provider, id);
+
+-- +goose Down
+ALTER TABLE webhook_events DROP CONSTRAINT webhook_events_pkey;
+ALTER TABLE webhook_events ADD PRIMARY KEY (id);
@@ -0,0 +1,11 @@
+-- +goose Up
+-- The webhook event that last set the period end, so replaying it doesn't
+-- extend the period again.
+ALTER TABLE subscriptions
+    ADD COLUMN period_event_provider text,
+    ADD COLUMN period_event_id       text;
+
+-- +goose Down
+ALTER TABLE subscriptions
+    DROP COLUMN period_event_provider,
+    DROP COLUMN period_event_id;
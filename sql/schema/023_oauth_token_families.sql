@@ -0,0 +1,19 @@
+-- +goose Up
+-- Refresh tokens rotated from the same authorization code share a family, so
+-- a replayed code or a reused refresh token can revoke all of them.
+ALTER TABLE oauth_authorization_codes
+    ADD COLUMN family_id uuid NOT NULL DEFAULT gen_random_uuid();
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN family_id uuid;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id) WHERE family_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_refresh_tokens_family_id;
+
+ALTER TABLE refresh_tokens
+    DROP COLUMN family_id;
+
+ALTER TABLE oauth_authorization_codes
+    DROP COLUMN family_id;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Avatars are uploaded and served by Chirpy rather than linked from
+-- anywhere, so profiles can't be used to load arbitrary third-party URLs.
+CREATE TABLE avatars
+(
+    user_id      uuid PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    updated_at   timestamp NOT NULL,
+    content_type text      NOT NULL,
+    data         bytea     NOT NULL
+);
+
+UPDATE users
+SET avatar_url = ''
+WHERE avatar_url <> '';
+
+-- +goose Down
+DROP TABLE avatars;
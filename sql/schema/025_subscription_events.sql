@@ -0,0 +1,35 @@
+-- +goose Up
+-- Every webhook event that has set a subscription's period, not just the
+-- latest, so replaying any of them can't extend the period again.
+CREATE TABLE subscription_events
+(
+    provider   text      NOT NULL,
+    event_id   text      NOT NULL,
+    user_id    uuid      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    applied_at timestamp NOT NULL,
+    PRIMARY KEY (provider, event_id)
+);
+
+INSERT INTO subscription_events (provider, event_id, user_id, applied_at)
+SELECT period_event_provider, period_event_id, user_id, updated_at
+FROM subscriptions
+WHERE period_event_id IS NOT NULL;
+
+ALTER TABLE subscriptions
+    DROP COLUMN period_event_provider,
+    DROP COLUMN period_event_id;
+
+-- +goose Down
+ALTER TABLE subscriptions
+    ADD COLUMN period_event_provider text,
+    ADD COLUMN period_event_id       text;
+
+UPDATE subscriptions
+SET period_event_provider = latest.provider,
+    period_event_id       = latest.event_id
+FROM (SELECT DISTINCT ON (user_id) user_id, provider, event_id
+      FROM subscription_events
+      ORDER BY user_id, applied_at DESC) latest
+WHERE subscriptions.user_id = latest.user_id;
+
+DROP TABLE subscription_events;
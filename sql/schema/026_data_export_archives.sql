@@ -0,0 +1,24 @@
+-- +goose Up
+-- Export archives are kept in the database rather than on one server's
+-- disk, so any replica can serve or remove them. Archives already written
+-- to disk aren't carried over; those exports have to be requested again.
+CREATE TABLE data_export_archives
+(
+    export_id uuid PRIMARY KEY REFERENCES data_exports (id) ON DELETE CASCADE,
+    data      bytea NOT NULL
+);
+
+DELETE FROM data_exports
+WHERE status = 'ready';
+
+ALTER TABLE data_exports
+    DROP COLUMN file_path;
+
+-- +goose Down
+ALTER TABLE data_exports
+    ADD COLUMN file_path text;
+
+DELETE FROM data_exports
+WHERE status = 'ready';
+
+DROP TABLE data_export_archives;
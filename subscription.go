@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/billing"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"time"
+)
+
+// Subscriptions
+type Subscription struct {
+	Plan              string     `json:"plan"`
+	Status            string     `json:"status"`
+	CurrentPeriodEnd  time.Time  `json:"current_period_end"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CanceledAt        *time.Time `json:"canceled_at"`
+	GraceUntil        *time.Time `json:"grace_until"`
+}
+
+func SubscriptionFromDb(dbSubscription database.Subscription) *Subscription {
+	return &Subscription{
+		Plan:              dbSubscription.Plan,
+		Status:            dbSubscription.Status,
+		CurrentPeriodEnd:  dbSubscription.CurrentPeriodEnd,
+		CancelAtPeriodEnd: dbSubscription.CancelAtPeriodEnd,
+		CanceledAt:        nullTimePtr(dbSubscription.CanceledAt),
+		GraceUntil:        nullTimePtr(dbSubscription.GraceUntil),
+	}
+}
+
+// activateSubscription starts or renews a membership. Providers normally
+// say when the new period ends; if one doesn't, the period runs on from the
+// end of the current one for as long as the plan pays for. Every event
+// applied is recorded, so replaying any of them, not just the latest,
+// doesn't extend the period again.
+func (cfg *apiConfig) activateSubscription(ctx context.Context, qtx *database.Queries, userID uuid.UUID, provider string, event billing.Event) error {
+	_, err := qtx.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errWebhookUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := qtx.RecordSubscriptionEvent(ctx, database.RecordSubscriptionEventParams{
+		Provider: provider, EventID: event.ID, UserID: userID,
+	})
+	if err != nil || rows == 0 {
+		return err
+	}
+
+	existing, err := qtx.GetSubscription(ctx, userID)
+	hasExisting := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{ID: userID, IsChirpyRed: true})
+	if err != nil {
+		return err
+	}
+
+	planID := event.PlanID
+	if plan, ok := billing.FindPlanByPrice(cfg.plans, provider, event.PriceID); ok {
+		planID = plan.ID
+	}
+	periodStart := time.Now()
+	if hasExisting {
+		if planID == "" {
+			planID = existing.Plan
+		}
+		if existing.Status != "expired" && existing.CurrentPeriodEnd.After(periodStart) {
+			periodStart = existing.CurrentPeriodEnd
+		}
+	}
+	plan, ok := billing.FindPlan(cfg.plans, planID)
+	if !ok {
+		plan = cfg.plans[0]
+	}
+	periodEnd := periodStart.Add(plan.Period())
+	if event.CurrentPeriodEnd != nil {
+		periodEnd = *event.CurrentPeriodEnd
+	}
+
+	_, err = qtx.ActivateSubscription(ctx, database.ActivateSubscriptionParams{
+		UserID:           userID,
+		Plan:             plan.ID,
+		CurrentPeriodEnd: periodEnd,
+	})
+	return err
+}
+
+// cancelSubscription stops a membership renewing. The user keeps their
+// benefits until the period they have paid for ends, even if the
+// cancellation is delivered more than once.
+func (cfg *apiConfig) cancelSubscription(ctx context.Context, qtx *database.Queries, userID uuid.UUID) error {
+	rows, err := qtx.CancelSubscription(ctx, userID)
+	if err != nil || rows > 0 {
+		return err
+	}
+	_, err = qtx.GetSubscription(ctx, userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Without a subscription to run out, there's nothing to wait for.
+	rows, err = qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{ID: userID, IsChirpyRed: false})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errWebhookUnknownUser
+	}
+	return nil
+}
+
+// markSubscriptionPastDue starts the grace period after a failed payment.
+// Further failures don't extend it.
+func (cfg *apiConfig) markSubscriptionPastDue(ctx context.Context, qtx *database.Queries, userID uuid.UUID) error {
+	rows, err := qtx.MarkSubscriptionPastDue(ctx, database.MarkSubscriptionPastDueParams{
+		UserID:     userID,
+		GraceUntil: sql.NullTime{Time: time.Now().Add(cfg.subscriptionGracePeriod), Valid: true},
+	})
+	if err != nil || rows > 0 {
+		return err
+	}
+
+	_, err = qtx.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errWebhookUnknownUser
+	}
+	return err
+}
+
+// expireSubscriptions ends memberships that have lapsed, whether by
+// cancellation, failed payment or a renewal that never arrived.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) error {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.withTx(tx)
+	userIDs, err := qtx.ExpireLapsedSubscriptions(ctx, cfg.subscriptionGracePeriod.Seconds())
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		_, err = qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{ID: userID, IsChirpyRed: false})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) getSubscriptionHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbSubscription, err := cfg.db.GetSubscription(request.Context(), principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendJsonNotFoundError(writer, "No subscription.")
+		return
+	}
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, SubscriptionFromDb(dbSubscription))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/google/uuid"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"strings"
+	"time"
+)
+
+// Personal access tokens
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func PersonalAccessTokenFromDb(dbToken database.PersonalAccessToken) *PersonalAccessToken {
+	return &PersonalAccessToken{
+		ID:         dbToken.ID,
+		CreatedAt:  dbToken.CreatedAt,
+		Name:       dbToken.Name,
+		Prefix:     dbToken.TokenPrefix,
+		Scopes:     dbToken.Scopes,
+		ExpiresAt:  nullTimePtr(dbToken.ExpiresAt),
+		LastUsedAt: nullTimePtr(dbToken.LastUsedAt),
+	}
+}
+
+func (cfg *apiConfig) createTokenHandler(writer http.ResponseWriter, request *http.Request) {
+	type createTokenPostBody struct {
+		Name             string   `json:"name"`
+		Scopes           []string `json:"scopes"`
+		ExpiresInSeconds int64    `json:"expires_in_seconds"`
+	}
+	type createTokenResponse struct {
+		*PersonalAccessToken
+		Token string `json:"token"`
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	params := createTokenPostBody{}
+	err := decodePostBody(request.Body, &params)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		sendJsonBadRequestError(writer, "name is required")
+		return
+	}
+	err = auth.ValidateScopes(params.Scopes, auth.PersonalAccessTokenScopes)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+	if params.ExpiresInSeconds < 0 {
+		sendJsonBadRequestError(writer, "expires_in_seconds must not be negative")
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInSeconds > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(time.Duration(params.ExpiresInSeconds) * time.Second), Valid: true}
+	}
+
+	token, prefix, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	dbToken, err := cfg.db.CreatePersonalAccessToken(request.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:      principal.UserID,
+		Name:        name,
+		TokenHash:   auth.HashToken(token),
+		TokenPrefix: prefix,
+		Scopes:      params.Scopes,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// The token itself is only ever shown here; we only keep its hash.
+	sendJsonCreatedResponse(writer, createTokenResponse{PersonalAccessToken: PersonalAccessTokenFromDb(dbToken), Token: token})
+}
+
+func (cfg *apiConfig) getTokensHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbTokens, err := cfg.db.GetPersonalAccessTokensForUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	tokens := make([]*PersonalAccessToken, 0, len(dbTokens))
+	for _, dbToken := range dbTokens {
+		tokens = append(tokens, PersonalAccessTokenFromDb(dbToken))
+	}
+
+	sendJsonSuccessResponse(writer, tokens)
+}
+
+func (cfg *apiConfig) revokeTokenHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	id, err := uuid.Parse(request.PathValue("tokenID"))
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	rows, err := cfg.db.RevokePersonalAccessToken(request.Context(), database.RevokePersonalAccessTokenParams{ID: id, UserID: principal.UserID})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonNotFoundError(writer, "Token not found.")
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
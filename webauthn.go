@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"net/http"
+	"pjh.id.au/chirpy/v2/internal/auth"
+	"pjh.id.au/chirpy/v2/internal/database"
+	"pjh.id.au/chirpy/v2/internal/passkey"
+	"strings"
+	"time"
+)
+
+// Passkeys
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	webAuthnSessionTTL   = 5 * time.Minute
+)
+
+type Passkey struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func PasskeyFromDb(dbCredential database.WebauthnCredential) *Passkey {
+	return &Passkey{
+		ID:         base64.RawURLEncoding.EncodeToString(dbCredential.ID),
+		CreatedAt:  dbCredential.CreatedAt,
+		Name:       dbCredential.Name,
+		LastUsedAt: nullTimePtr(dbCredential.LastUsedAt),
+	}
+}
+
+func credentialFromDb(dbCredential database.WebauthnCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(dbCredential.Transports))
+	for _, transport := range dbCredential.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+	return webauthn.Credential{
+		ID:              dbCredential.ID,
+		PublicKey:       dbCredential.PublicKey,
+		AttestationType: dbCredential.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.CredentialFlags{BackupEligible: dbCredential.BackupEligible, BackupState: dbCredential.BackupState},
+		Authenticator:   webauthn.Authenticator{AAGUID: dbCredential.Aaguid, SignCount: uint32(dbCredential.SignCount)},
+	}
+}
+
+func (cfg *apiConfig) loadPasskeyUser(ctx context.Context, userID uuid.UUID) (*passkey.User, error) {
+	dbUser, err := cfg.db.GetUser(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, passkey.ErrUnknownCredential
+	}
+	if err != nil {
+		return nil, err
+	}
+	dbCredentials, err := cfg.db.GetWebAuthnCredentialsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &passkey.User{ID: dbUser.ID, Email: dbUser.Email}
+	for _, dbCredential := range dbCredentials {
+		user.Credentials = append(user.Credentials, credentialFromDb(dbCredential))
+	}
+	return user, nil
+}
+
+// saveWebAuthnSession keeps ceremony state server-side between the begin and
+// finish requests. The client only ever sees the session ID.
+func (cfg *apiConfig) saveWebAuthnSession(ctx context.Context, userID uuid.NullUUID, ceremony string, session *webauthn.SessionData) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	dbSession, err := cfg.db.CreateWebAuthnSession(ctx, database.CreateWebAuthnSessionParams{
+		UserID: userID, Ceremony: ceremony, Data: data, ExpiresAt: time.Now().Add(webAuthnSessionTTL),
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return dbSession.ID, nil
+}
+
+// consumeWebAuthnSession loads and deletes a session in one step so each
+// challenge can only be answered once.
+func (cfg *apiConfig) consumeWebAuthnSession(ctx context.Context, request *http.Request, ceremony string) (*webauthn.SessionData, uuid.NullUUID, error) {
+	id, err := uuid.Parse(request.URL.Query().Get("session_id"))
+	if err != nil {
+		return nil, uuid.NullUUID{}, errors.New("invalid session_id")
+	}
+
+	dbSession, err := cfg.db.ConsumeWebAuthnSession(ctx, database.ConsumeWebAuthnSessionParams{ID: id, Ceremony: ceremony})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, uuid.NullUUID{}, errors.New("unknown or already used session_id")
+	}
+	if err != nil {
+		return nil, uuid.NullUUID{}, err
+	}
+	if dbSession.ExpiresAt.Before(time.Now()) {
+		return nil, uuid.NullUUID{}, errors.New("session has expired")
+	}
+
+	session := webauthn.SessionData{}
+	err = json.Unmarshal(dbSession.Data, &session)
+	if err != nil {
+		return nil, uuid.NullUUID{}, err
+	}
+	return &session, dbSession.UserID, nil
+}
+
+func webAuthnErrorMessage(err error) string {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.Details != "" {
+		return protocolErr.Details
+	}
+	return err.Error()
+}
+
+type webAuthnBeginResponse struct {
+	SessionID uuid.UUID   `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+func (cfg *apiConfig) beginPasskeyRegistrationHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	user, err := cfg.loadPasskeyUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	options, session, err := cfg.passkeys.BeginRegistration(user)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sessionID, err := cfg.saveWebAuthnSession(request.Context(), uuid.NullUUID{UUID: user.ID, Valid: true}, ceremonyRegistration, session)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, webAuthnBeginResponse{SessionID: sessionID, Options: options})
+}
+
+func (cfg *apiConfig) finishPasskeyRegistrationHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	session, sessionUserID, err := cfg.consumeWebAuthnSession(request.Context(), request, ceremonyRegistration)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+	if !sessionUserID.Valid || sessionUserID.UUID != principal.UserID {
+		sendJsonForbiddenError(writer, "Session belongs to another user")
+		return
+	}
+
+	user, err := cfg.loadPasskeyUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	credential, err := cfg.passkeys.FinishRegistration(user, *session, request)
+	if err != nil {
+		sendJsonBadRequestError(writer, webAuthnErrorMessage(err))
+		return
+	}
+
+	name := strings.TrimSpace(request.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	dbCredential, err := cfg.db.CreateWebAuthnCredential(request.Context(), database.CreateWebAuthnCredentialParams{
+		ID:              credential.ID,
+		UserID:          principal.UserID,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		Aaguid:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	})
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	sendJsonCreatedResponse(writer, PasskeyFromDb(dbCredential))
+}
+
+func (cfg *apiConfig) getPasskeysHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	dbCredentials, err := cfg.db.GetWebAuthnCredentialsForUser(request.Context(), principal.UserID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	passkeys := make([]*Passkey, 0, len(dbCredentials))
+	for _, dbCredential := range dbCredentials {
+		passkeys = append(passkeys, PasskeyFromDb(dbCredential))
+	}
+
+	sendJsonSuccessResponse(writer, passkeys)
+}
+
+func (cfg *apiConfig) deletePasskeyHandler(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+
+	id, err := base64.RawURLEncoding.DecodeString(request.PathValue("credentialID"))
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	rows, err := cfg.db.DeleteWebAuthnCredential(request.Context(), database.DeleteWebAuthnCredentialParams{ID: id, UserID: principal.UserID})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonNotFoundError(writer, "Passkey not found.")
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) beginPasskeyLoginHandler(writer http.ResponseWriter, request *http.Request) {
+	options, session, err := cfg.passkeys.BeginLogin()
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sessionID, err := cfg.saveWebAuthnSession(request.Context(), uuid.NullUUID{}, ceremonyLogin, session)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	sendJsonSuccessResponse(writer, webAuthnBeginResponse{SessionID: sessionID, Options: options})
+}
+
+func (cfg *apiConfig) finishPasskeyLoginHandler(writer http.ResponseWriter, request *http.Request) {
+	session, _, err := cfg.consumeWebAuthnSession(request.Context(), request, ceremonyLogin)
+	if err != nil {
+		sendJsonBadRequestError(writer, err.Error())
+		return
+	}
+
+	lookup := func(userID uuid.UUID) (*passkey.User, error) {
+		return cfg.loadPasskeyUser(request.Context(), userID)
+	}
+	user, credential, err := cfg.passkeys.FinishLogin(lookup, *session, request)
+	if err != nil {
+		cfg.metrics.Logins.WithLabelValues(loginMethodPasskey, "failure").Inc()
+		sendJsonUnauthorizedError(writer, webAuthnErrorMessage(err))
+		return
+	}
+
+	// The conditional update also catches two logins racing with the same
+	// counter value.
+	rows, err := cfg.db.UpdateWebAuthnCredentialUse(request.Context(), database.UpdateWebAuthnCredentialUseParams{
+		ID: credential.ID, SignCount: int64(credential.Authenticator.SignCount), BackupState: credential.Flags.BackupState,
+	})
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+	if rows == 0 {
+		sendJsonUnauthorizedError(writer, passkey.ErrClonedAuthenticator.Error())
+		return
+	}
+
+	dbUser, err := cfg.db.GetUser(request.Context(), user.ID)
+	if err != nil {
+		sendJsonInternalServerError(writer, err.Error())
+		return
+	}
+
+	// A passkey with user verification is already multi-factor, so this
+	// skips the TOTP challenge.
+	cfg.sendLoginResponse(writer, request, dbUser, loginMethodPasskey)
+}
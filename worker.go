@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Background workers
+
+// runWorker calls work every interval until ctx is cancelled. Errors are
+// logged and the worker carries on at the next tick.
+func runWorker(ctx context.Context, name string, interval time.Duration, work func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := work(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error running worker", "worker", name, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// workerGroup runs background workers until they are stopped.
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel}
+}
+
+func (g *workerGroup) start(name string, interval time.Duration, work func(context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		runWorker(g.ctx, name, interval, work)
+	}()
+}
+
+// stop cancels the workers' context and waits for any work in progress to
+// finish, or for ctx to be done.
+func (g *workerGroup) stop(ctx context.Context) error {
+	g.cancel()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deleteExpiredTokens clears out short-lived login state that can no longer
+// be used, and old outbox emails that may contain links.
+func (cfg *apiConfig) deleteExpiredTokens(ctx context.Context) error {
+	err := cfg.db.DeleteExpiredWebAuthnSessions(ctx)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeleteExpiredMagicLinkTokens(ctx)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeleteExpiredOIDCLoginStates(ctx)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeleteExpiredOAuthAuthorizationCodes(ctx)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.DeleteOldEmails(ctx, outboxRetention.Seconds())
+	if err != nil {
+		return err
+	}
+	return cfg.deleteExpiredDataExports(ctx)
+}